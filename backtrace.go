@@ -0,0 +1,179 @@
+package lisp
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Frame is one application in a backtrace
+type Frame struct {
+	Form Obj     // the form being applied
+	Name *Symbol // the name the applied procedure or macro was defined with, nil if unknown
+	Pos  Pos     // where Form was read, if it was
+
+	// procedure calls made in tail position before this one were dropped,
+	// to keep the stack from growing in loops
+	Elided bool
+}
+
+// an entry on the interpreter's call stack, turned into a Frame only when a
+// backtrace is taken
+type frame struct {
+	form   Obj
+	proc   Obj
+	elided bool
+}
+
+// each call to Eval gets a frame for the first application it makes, and
+// the tail calls after it share a second one, so that loops written with
+// tail calls run in constant space. base is the stack depth Eval started at.
+//
+// The frame is pushed once the procedure applied is known, so it's there
+// while the arguments are evaluated. Special forms and macro uses don't get
+// frames, since the VM compiles them away. The VM keeps the stack the same
+// way, see vm.call.
+func (i *Interpreter) pushFrame(base int, form, proc Obj) {
+	if len(i.stack)-base < 2 {
+		i.stack = append(i.stack, frame{form: form, proc: proc})
+		return
+	}
+	i.replaceFrame(form, proc)
+}
+
+// replaces the frame on top of the stack with a tail call
+func (i *Interpreter) replaceFrame(form, proc Obj) {
+	top := &i.stack[len(i.stack)-1]
+	if _, ok := top.proc.(*Procedure); ok {
+		top.elided = true
+	}
+	top.form, top.proc = form, proc
+}
+
+// the form being applied at the top of the stack, nil if there isn't one
+func (i *Interpreter) currentForm() Obj {
+	if len(i.stack) == 0 {
+		return nil
+	}
+	return i.stack[len(i.stack)-1].form
+}
+
+// backtrace returns the call stack, innermost application first, leaving
+// out the frames the VM gives special forms it compiles into calls
+func (i *Interpreter) backtrace() []Frame {
+	frames := make([]Frame, 0, len(i.stack))
+	for n := len(i.stack) - 1; n >= 0; n-- {
+		f := i.stack[n]
+		if _, ok := f.proc.(Primitive); ok {
+			continue
+		}
+		frames = append(frames, Frame{
+			Form:   f.form,
+			Name:   procName(f.proc),
+			Pos:    i.posOf(f.form),
+			Elided: f.elided,
+		})
+	}
+	return frames
+}
+
+func procName(proc Obj) *Symbol {
+	switch proc := proc.(type) {
+	case *Procedure:
+		return proc.name
+	case *Macro:
+		return proc.name
+	}
+	return nil
+}
+
+// longer backtraces are printed with the middle left out
+const maxPrintedFrames = 20
+
+// one frame per line, for error messages. the first frame is left out if
+// skipFirst is set, except for the calls elided before it.
+func formatBacktrace(frames []Frame, skipFirst bool) string {
+	b := strings.Builder{}
+	b.WriteString("backtrace:")
+	if skipFirst && len(frames) > 0 {
+		if frames[0].Elided {
+			b.WriteString("\n  ...")
+		}
+		frames = frames[1:]
+	}
+	for n, f := range frames {
+		if len(frames) > maxPrintedFrames && n == maxPrintedFrames/2 {
+			fmt.Fprintf(&b, "\n  ... %v more", len(frames)-maxPrintedFrames)
+		}
+		if len(frames) > maxPrintedFrames && n >= maxPrintedFrames/2 && n < len(frames)-maxPrintedFrames/2 {
+			continue
+		}
+		b.WriteString("\n  ")
+		b.WriteString(f.String())
+		if f.Elided {
+			b.WriteString("\n  ...")
+		}
+	}
+	return b.String()
+}
+
+// forms longer than this are cut short in backtraces
+const maxFormLength = 60
+
+// (f x) [g] at file:line:col, where g is the procedure applied if it isn't
+// what the head of the form is called
+func (f Frame) String() string {
+	b := strings.Builder{}
+	form := mustStringer(f.Form).String()
+	if len(form) > maxFormLength {
+		form = form[:maxFormLength-3] + "..."
+	}
+	b.WriteString(form)
+	if f.Name != nil {
+		pair, ok := f.Form.(*Pair)
+		if !ok || !f.Name.Equal(pair.Car) {
+			b.WriteString(" [")
+			b.WriteString(f.Name.String())
+			b.WriteString("]")
+		}
+	}
+	if f.Pos.IsValid() {
+		b.WriteString(" at ")
+		b.WriteString(f.Pos.String())
+	}
+	return b.String()
+}
+
+// each frame as a list of (name form location), where name is #f if the
+// procedure applied isn't known by one and location is a "file:line:col"
+// string or #f
+func backtraceToList(frames []Frame) Obj {
+	objs := make([]Obj, 0, len(frames))
+	for _, f := range frames {
+		name, location := Obj(False), Obj(False)
+		if f.Name != nil {
+			name = f.Name
+		}
+		if f.Pos.IsValid() {
+			location = MakeString(f.Pos.String())
+		}
+		objs = append(objs, List(name, f.Form, location))
+	}
+	return sliceToList(objs)
+}
+
+// returns the frames of the applications currently being evaluated,
+// innermost first, leaving out the call to backtrace itself
+func BacktracePrim(args []Obj, e *Env) Obj {
+	if len(args) != 0 {
+		panic(MakeError(ArityErrorSym, "backtrace takes no arguments"))
+	}
+	frames := e.interp.backtrace()
+	if len(frames) > 0 {
+		frames = frames[1:]
+	}
+	return backtraceToList(frames)
+}
+
+func ErrorBacktracePrim(args []Obj, e *Env) Obj {
+	return backtraceToList(errorArg("error-object-backtrace", args).Backtrace)
+}
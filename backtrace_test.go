@@ -0,0 +1,82 @@
+package lisp
+
+import (
+	"fmt"
+	"testing"
+)
+
+// errors print the applications they were raised in, innermost first, with
+// the names procedures were defined with and where they were read
+func TestErrorBacktraces(t *testing.T) {
+	tests := []struct {
+		src, want string
+	}{
+		{`
+(define f (lambda (x) (car x)))
+(define g (lambda (x) (+ 1 (f x))))
+(g 5)`, `type-error: car takes pairs as arguments 5
+  in: (car x) at 2:23
+backtrace:
+  (f x) at 3:28
+  (+ 1 (f x)) at 3:23
+  (g 5) at 4:1`},
+		// the calls made by a loop are left out
+		{`
+(define loop (lambda (n) (if (= n 0) (car n) (loop (- n 1)))))
+(define h (lambda () (list (loop 3))))
+(h)`, `type-error: car takes pairs as arguments 0
+  in: (car n) at 2:38
+backtrace:
+  ...
+  (loop 3) at 3:28
+  (list (loop 3)) at 3:22
+  (h) at 4:1`},
+		{`
+(define apply-it (lambda (k) (k)))
+(apply-it (lambda () (raise 'oops)))`, `uncaught raise: oops
+backtrace:
+  (raise (quote oops)) at 3:22
+  ...
+  (apply-it (lambda () (raise (quote oops)))) at 3:1`},
+		// applications have frames while their arguments are evaluated,
+		// but special forms don't
+		{`
+(define f (lambda (x) (if (car x) 1 2)))
+(list 1 (f 2))`, `type-error: car takes pairs as arguments 2
+  in: (car x) at 2:27
+backtrace:
+  (f 2) at 3:9
+  (list 1 (f 2)) at 3:1`},
+	}
+	for _, engine := range []Engine{TreeEngine, VMEngine} {
+		for _, test := range tests {
+			_, err := New(WithEngine(engine)).EvalString(test.src)
+			if err == nil || err.Error() != test.want {
+				t.Errorf("got\n%v\nwant\n%v", err, test.want)
+			}
+		}
+	}
+}
+
+func TestBacktracePrimitives(t *testing.T) {
+	tests := []struct {
+		src, want string
+	}{
+		{`
+(define f (lambda () (backtrace)))
+(define g (lambda () (list (f))))
+(g)`, `(((f (f) "3:28") (list (list (f)) "3:22") (g (g) "4:1")))`},
+		{`
+(define f (lambda (x) (car x)))
+(guard (e (#t (error-object-backtrace e))) (f 1))`, `((#f (car x) "2:23") (f (f 1) "3:44"))`},
+		{`(list (backtrace))`, `(((list (list (backtrace)) "1:1")))`},
+	}
+	for _, engine := range []Engine{TreeEngine, VMEngine} {
+		for _, test := range tests {
+			result, err := New(WithEngine(engine)).EvalString(test.src)
+			if got := fmt.Sprint(result); err != nil || got != test.want {
+				t.Errorf("got %v, %v, want %v", got, err, test.want)
+			}
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package lisp_test
+
+import (
+	"io"
+	"testing"
+
+	"lisp"
+)
+
+var benchmarks = []struct {
+	name  string
+	setup string
+	run   string
+}{
+	{"fib", `
+(define fib (lambda (n) (if (< n 2) n (+ (fib (- n 1)) (fib (- n 2))))))`,
+		`(fib 20)`},
+	{"tak", `
+(define tak
+  (lambda (x y z)
+    (if (< y x)
+        (tak (tak (- x 1) y z) (tak (- y 1) z x) (tak (- z 1) x y))
+        z)))`,
+		`(tak 18 12 6)`},
+	{"loop", `
+(define loop (lambda (n acc) (if (= n 0) acc (loop (- n 1) (+ acc 1)))))`,
+		`(loop 100000 0)`},
+	{"closures", `
+(define make-adder (lambda (n) (lambda (x) (+ x n))))
+(define sum-adders
+  (lambda (n acc)
+    (if (= n 0) acc (sum-adders (- n 1) ((make-adder n) acc)))))`,
+		`(sum-adders 20000 0)`},
+	{"macros", `
+(define count-down
+  (lambda (n acc)
+    (if (= n 0) acc (let ((m (- n 1))) (count-down m (+ acc 1))))))`,
+		`(count-down 20000 0)`},
+}
+
+var benchEngines = []struct {
+	name   string
+	engine lisp.Engine
+}{
+	{"vm", lisp.VMEngine},
+	{"tree", lisp.TreeEngine},
+}
+
+func BenchmarkEngines(b *testing.B) {
+	for _, bench := range benchmarks {
+		for _, engine := range benchEngines {
+			b.Run(bench.name+"/"+engine.name, func(b *testing.B) {
+				interp := lisp.New(lisp.WithEngine(engine.engine))
+				if _, err := interp.EvalString(bench.setup); err != nil {
+					b.Fatal(err)
+				}
+				b.ReportAllocs()
+				b.ResetTimer()
+				for n := 0; n < b.N; n++ {
+					if _, err := interp.EvalString(bench.run); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
+
+// a whole program that leans on macros, solving 8 queens. it redefines
+// procedures like print, so each run gets a new interpreter.
+func BenchmarkNQueens(b *testing.B) {
+	for _, engine := range benchEngines {
+		b.Run(engine.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for n := 0; n < b.N; n++ {
+				b.StopTimer()
+				interp := lisp.New(lisp.WithEngine(engine.engine), lisp.WithOutput(io.Discard))
+				b.StartTimer()
+				if _, err := interp.EvalFile("examples/nqueens.lisp"); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
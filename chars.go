@@ -0,0 +1,157 @@
+package lisp
+
+import (
+	"fmt"
+	"strconv"
+	"unicode"
+	"unicode/utf8"
+)
+
+// the characters with names, like #\space, which are read and printed by name
+var charNames = map[string]rune{
+	"alarm":     '\a',
+	"backspace": '\b',
+	"delete":    0x7f,
+	"escape":    0x1b,
+	"newline":   '\n',
+	"null":      0,
+	"return":    '\r',
+	"space":     ' ',
+	"tab":       '\t',
+}
+
+var charsByName = func() map[rune]string {
+	names := map[rune]string{}
+	for name, r := range charNames {
+		names[r] = name
+	}
+	return names
+}()
+
+// parses what follows #\ in a character literal: a single character, a name
+// from charNames, or a hex scalar value like x41
+func parseChar(s string) (rune, bool) {
+	runes := []rune(s)
+	if len(runes) == 1 {
+		return runes[0], true
+	}
+	if r, ok := charNames[s]; ok {
+		return r, true
+	}
+	if len(runes) > 1 && runes[0] == 'x' {
+		n, err := strconv.ParseUint(string(runes[1:]), 16, 32)
+		if err == nil && utf8.ValidRune(rune(n)) {
+			return rune(n), true
+		}
+	}
+	return 0, false
+}
+
+// characters print so they can be read back in, see displayString for how
+// display prints them
+func (c *Char) String() string {
+	if name, ok := charsByName[c.r]; ok {
+		return `#\` + name
+	}
+	if unicode.IsControl(c.r) || !unicode.IsPrint(c.r) {
+		return fmt.Sprintf(`#\x%x`, c.r)
+	}
+	return `#\` + string(c.r)
+}
+
+var _ fmt.Stringer = &Char{}
+
+// checks that o is a character, for primitives that only take characters
+func charArg(name string, o Obj) rune {
+	c, ok := o.(*Char)
+	if !ok {
+		panic(MakeError(TypeErrorSym, fmt.Sprintf("%v takes character arguments", name), o))
+	}
+	return c.r
+}
+
+func oneCharArg(name string, args []Obj) rune {
+	if len(args) != 1 {
+		panic(MakeError(ArityErrorSym, name+" takes 1 argument"))
+	}
+	return charArg(name, args[0])
+}
+
+func IsCharPrim(args []Obj, e *Env) Obj {
+	if len(args) != 1 {
+		panic(MakeError(ArityErrorSym, "char? takes 1 argument"))
+	}
+	_, ok := args[0].(*Char)
+	return boolToLisp(ok)
+}
+
+func CharToIntegerPrim(args []Obj, e *Env) Obj {
+	return MakeInt(int64(oneCharArg("char->integer", args)))
+}
+
+func IntegerToCharPrim(args []Obj, e *Env) Obj {
+	if len(args) != 1 {
+		panic(MakeError(ArityErrorSym, "integer->char takes 1 argument"))
+	}
+	n := intArg("integer->char", args[0])
+	if n < 0 || n > unicode.MaxRune || !utf8.ValidRune(rune(n)) {
+		panic(MakeError(RangeErrorSym, "integer->char takes a unicode scalar value", args[0]))
+	}
+	return MakeChar(rune(n))
+}
+
+func CharUpcasePrim(args []Obj, e *Env) Obj {
+	return MakeChar(unicode.ToUpper(oneCharArg("char-upcase", args)))
+}
+
+func CharDowncasePrim(args []Obj, e *Env) Obj {
+	return MakeChar(unicode.ToLower(oneCharArg("char-downcase", args)))
+}
+
+func IsCharAlphabeticPrim(args []Obj, e *Env) Obj {
+	return boolToLisp(unicode.IsLetter(oneCharArg("char-alphabetic?", args)))
+}
+
+func IsCharNumericPrim(args []Obj, e *Env) Obj {
+	return boolToLisp(unicode.IsDigit(oneCharArg("char-numeric?", args)))
+}
+
+func IsCharWhitespacePrim(args []Obj, e *Env) Obj {
+	return boolToLisp(unicode.IsSpace(oneCharArg("char-whitespace?", args)))
+}
+
+// compares each adjacent pair of characters with cmp
+func compareChars(name string, args []Obj, cmp func(a, b rune) bool) Obj {
+	if len(args) < 1 {
+		panic(MakeError(ArityErrorSym, fmt.Sprintf("%v takes at least 1 argument", name)))
+	}
+	prev := charArg(name, args[0])
+	for _, arg := range args[1:] {
+		curr := charArg(name, arg)
+		if !cmp(prev, curr) {
+			return False
+		}
+		prev = curr
+	}
+	return True
+}
+
+func CharEqualPrim(args []Obj, e *Env) Obj {
+	return compareChars("char=?", args, func(a, b rune) bool { return a == b })
+}
+
+func CharLessPrim(args []Obj, e *Env) Obj {
+	return compareChars("char<?", args, func(a, b rune) bool { return a < b })
+}
+
+func CharGreaterPrim(args []Obj, e *Env) Obj {
+	return compareChars("char>?", args, func(a, b rune) bool { return a > b })
+}
+
+func CharLessEqPrim(args []Obj, e *Env) Obj {
+	return compareChars("char<=?", args, func(a, b rune) bool { return a <= b })
+}
+
+func CharGreaterEqPrim(args []Obj, e *Env) Obj {
+	return compareChars("char>=?", args, func(a, b rune) bool { return a >= b })
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+
+	"lisp"
+)
+
+var _, noREPL = os.LookupEnv("NO_REPL")
+
+// exprsFlag collects every -e flag in order
+type exprsFlag []string
+
+func (f *exprsFlag) String() string {
+	return strings.Join(*f, " ")
+}
+
+func (f *exprsFlag) Set(expr string) error {
+	*f = append(*f, expr)
+	return nil
+}
+
+var (
+	exprs       exprsFlag
+	interactive = flag.Bool("i", false, "start the REPL after running the script and expressions")
+	engine      = flag.String("engine", "vm", "evaluate with the bytecode `vm` or the tree-walking evaluator (tree)")
+	nilAsFalse  = flag.Bool("nil-as-false", false, "treat the empty list as false, for code written before #f")
+)
+
+func init() {
+	flag.Var(&exprs, "e", "evaluate `expr` before the script, can be repeated")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %v [-i] [-engine vm|tree] [-nil-as-false] [-e expr]... [file [args...]]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+}
+
+// usage: lisp [-i] [-engine vm|tree] [-nil-as-false] [-e expr]... [file [args...]]
+//
+// with no file or expressions, reads from stdin with a REPL
+func main() {
+	flag.Parse()
+
+	engines := map[string]lisp.Engine{"vm": lisp.VMEngine, "tree": lisp.TreeEngine}
+	e, ok := engines[*engine]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown engine %q\n", *engine)
+		os.Exit(2)
+	}
+	opts := []lisp.Option{lisp.WithEngine(e)}
+	if *nilAsFalse {
+		opts = append(opts, lisp.WithNilAsFalse())
+	}
+	interp := lisp.New(opts...)
+
+	// the script path followed by its arguments, as strings
+	commandLine := []lisp.Obj{}
+	for _, arg := range flag.Args() {
+		commandLine = append(commandLine, lisp.MakeString(arg))
+	}
+	interp.RegisterFunc("command-line", func(args []lisp.Obj) (lisp.Obj, error) {
+		if len(args) != 0 {
+			return nil, lisp.MakeError(lisp.ArityErrorSym, "command-line takes no args")
+		}
+		return lisp.List(commandLine...), nil
+	})
+
+	for _, expr := range exprs {
+		_, err := interp.EvalString(expr)
+		exitOnError(err)
+	}
+
+	if flag.NArg() > 0 {
+		_, err := interp.EvalFile(flag.Arg(0))
+		exitOnError(err)
+	}
+
+	if (len(exprs) > 0 || flag.NArg() > 0) && !*interactive {
+		return
+	}
+
+	repl(interp, interp.NewReader(os.Stdin, "<stdin>"))
+}
+
+// exits with the status passed to exit, or 1 if there was an error
+func exitOnError(err error) {
+	if err == nil {
+		return
+	}
+	if exit, ok := err.(*lisp.ExitError); ok {
+		os.Exit(exit.Code)
+	}
+	fmt.Fprintln(os.Stderr, "error:", err)
+	os.Exit(1)
+}
+
+func repl(interp *lisp.Interpreter, r *lisp.Reader) {
+	for {
+		if !noREPL {
+			fmt.Print("> ")
+		}
+		o, err := r.Next()
+		if err == io.EOF {
+			os.Exit(0)
+		}
+		if err == nil {
+			o, err = evalInterruptibly(interp, o)
+		}
+		if exit, ok := err.(*lisp.ExitError); ok {
+			os.Exit(exit.Code)
+		}
+		if err != nil && noREPL {
+			// stdin is a script, so it stops like one
+			exitOnError(err)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "error:", err)
+			continue
+		}
+		if !noREPL {
+			fmt.Print("< ")
+			lisp.Print(o)
+		}
+	}
+}
+
+// evaluates o, stopping it instead of exiting if ^C is pressed meanwhile
+func evalInterruptibly(interp *lisp.Interpreter, o lisp.Obj) (lisp.Obj, error) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	return interp.EvalContext(ctx, o)
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// the test binary runs main instead of the tests when this is set, so that
+// tests can run the command
+const runMainEnv = "LISP_TEST_RUN_MAIN"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(runMainEnv) != "" {
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// runs the command with args and stdin, returning its stdout, stderr and
+// exit status. NO_REPL is set unless prompts is.
+func run(t *testing.T, stdin string, prompts bool, args ...string) (string, string, int) {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Env = append(os.Environ(), runMainEnv+"=1")
+	if !prompts {
+		cmd.Env = append(cmd.Env, "NO_REPL=1")
+	}
+	cmd.Stdin = strings.NewReader(stdin)
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	cmd.Stdout, cmd.Stderr = stdout, stderr
+	err := cmd.Run()
+	var exit *exec.ExitError
+	if errors.As(err, &exit) {
+		return stdout.String(), stderr.String(), exit.ExitCode()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return stdout.String(), stderr.String(), 0
+}
+
+func writeScript(t *testing.T, src string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "script.lisp")
+	if err := os.WriteFile(path, []byte(src), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestCommandLine(t *testing.T) {
+	script := writeScript(t, "#!/usr/bin/env lisp\n(print (cdr (command-line)))\n(print x)\n")
+	failing := writeScript(t, "(print 'before)\n(car 5)\n(print 'after)\n")
+	exiting := writeScript(t, "(exit 3)\n")
+	tests := []struct {
+		name    string
+		stdin   string
+		prompts bool
+		args    []string
+		stdout  string
+		status  int
+	}{
+		{"script with arguments", "", false, []string{"-e", "(define x 'from-e)", script, "a", "b"}, "(\"a\" \"b\")\nfrom-e\n", 0},
+		{"expressions in order", "", false, []string{"-e", "(define y 1)", "-e", "(print (+ y 1))"}, "2\n", 0},
+		{"error", "", false, []string{failing}, "before\n", 1},
+		{"exit", "", false, []string{exiting}, "", 3},
+		{"REPL after script", "(print (* 6 7))\n", false, []string{"-i", "-e", "(define x 'defined)", script}, "()\ndefined\n42\n", 0},
+		{"script on stdin", "(print 'before)\n(car 5)\n(print 'after)\n", false, nil, "before\n", 1},
+		{"REPL", "(define z 5)\n(car 5)\n(print 'carried-on)\n", true, nil, "> < 5\n> > carried-on\n< ()\n> ", 0},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			stdout, stderr, status := run(t, test.stdin, test.prompts, test.args...)
+			if status != test.status {
+				t.Errorf("exit status %v, want %v\nstderr: %v", status, test.status, stderr)
+			}
+			if stdout != test.stdout {
+				t.Errorf("got %q, want %q", stdout, test.stdout)
+			}
+			if (test.status == 1 || test.prompts) && !strings.HasPrefix(stderr, "error: ") {
+				t.Errorf("got %q on stderr, want the error", stderr)
+			}
+		})
+	}
+}
@@ -0,0 +1,708 @@
+package lisp
+
+import "fmt"
+
+/*
+The compiler turns forms into bytecode for the VM in vm.go. Macros are
+expanded as it goes, and the special forms it knows about are compiled into
+jumps and bindings. Anything else, like special forms added from Go or
+macros defined after the code using them was compiled, is left for Eval to
+do when the code runs.
+*/
+
+type opcode uint8
+
+const (
+	opConst       opcode = iota // push consts[arg]
+	opLoad                      // push the value of the variable consts[arg]
+	opLoadLocal                 // push the value of the variable in locals[arg]
+	opHead                      // push the value of the head of calls[arg], see vm.head
+	opFrame                     // push a frame for calls[arg], whose procedure is on top of the stack
+	opTailFrame                 // like opFrame, but in place of the current frame, see pushFrame
+	opSet                       // pop a value and set! consts[arg] to it, pushing the old one
+	opSetLocal                  // like opSet, for the variable in locals[arg]
+	opDefine                    // bind consts[arg] to the value on top of the stack
+	opDefineLocal               // like opDefine, for the variable in locals[arg]
+	opPop                       // drop the value on top of the stack
+	opDup                       // push the value on top of the stack again
+	opJump                      // go to arg
+	opJumpIfFalse               // pop a value and go to arg if it's false
+	opLambda                    // push a procedure made from lambdas[arg]
+	opCall                      // apply calls[arg] to the values on top of the stack, using its frame
+	opTailCall                  // like opCall, but in place of the current frame
+	opReturn                    // return the value on top of the stack
+	opEval                      // push the value of consts[arg] evaluated with Eval
+	opGuard                     // push the value of guards[arg]
+	opRaise                     // raise consts[arg]
+)
+
+// instr is an opcode in the low byte with its argument in the rest
+type instr uint32
+
+func makeInstr(op opcode, arg int) instr {
+	return instr(arg)<<8 | instr(op)
+}
+
+func (in instr) op() opcode {
+	return opcode(in & 0xff)
+}
+
+func (in instr) arg() int {
+	return int(in >> 8)
+}
+
+// code is a compiled form or body
+type code struct {
+	instrs  []instr
+	consts  []Obj
+	calls   []callSite
+	locals  []local
+	lambdas []*lambda
+	guards  []*guard
+}
+
+// callSite is an application of nargs arguments, which the VM finds on the
+// stack after the procedure
+type callSite struct {
+	form   Obj // for backtraces, and for Eval if the head turns out to be a macro
+	nargs  int
+	end    int       // the instruction after the call
+	syntax Primitive // the special form compiled into the call, which its frame is for
+}
+
+// local is a variable in the slots of an environment depth levels up from
+// the one the code runs in
+type local struct {
+	sym   *Symbol
+	depth int
+	index int
+}
+
+// lambda is shared by the procedures made from a lambda form, so that its
+// body is only compiled once, the first time one of them is called
+type lambda struct {
+	args     []Symbol
+	variadic *Symbol
+	body     Obj
+	names    []Symbol // the slots of a call: the arguments, then the variables defined in the body
+	outer    *scope   // the variables around the lambda form
+	env      *Env     // the environment the lambda form was compiled for
+	code     *code
+
+	// the macros and special forms code expanded or compiled, so that
+	// it's compiled again if one of them is redefined, like Eval would see
+	syntax  []syntaxUse
+	checked uint // the interpreter's syntaxChanges when syntax was last checked
+}
+
+// syntaxUse is a head the compiler found bound to a macro or special form
+type syntaxUse struct {
+	sym   *Symbol
+	value Obj
+}
+
+// guard is a compiled guard form. body runs in the environment of the
+// guard, and handler in a new one binding name to the condition.
+type guard struct {
+	name    *Symbol
+	names   []Symbol // the slots of the handler's environment, just name
+	body    *code
+	handler *code
+}
+
+// returned by guard handlers when no clause matches
+var noClauseMatched = MakeString("no clause matched")
+
+// scope is the variables bound by the lambdas around a form, in the order
+// of the slots they're kept in at runtime. Each scope is an environment
+// when the code runs, so the compiler knows where to find its variables, and
+// can tell them apart from the macros and special forms they shadow.
+type scope struct {
+	names  []Symbol
+	parent *scope
+}
+
+// where sym is, in the innermost scope that has it. later names shadow
+// earlier ones, like arguments with the same name do in Eval.
+func (s *scope) lookup(sym *Symbol) (depth, index int, ok bool) {
+	for ; s != nil; s, depth = s.parent, depth+1 {
+		for n := len(s.names) - 1; n >= 0; n-- {
+			if s.names[n] == *sym {
+				return depth, n, true
+			}
+		}
+	}
+	return 0, 0, false
+}
+
+// whether sym is in s itself, leaving out the scopes around it
+func (s *scope) has(sym *Symbol) bool {
+	for _, name := range s.names {
+		if name == *sym {
+			return true
+		}
+	}
+	return false
+}
+
+type compiler struct {
+	interp    *Interpreter
+	env       *Env    // where the code will run, for finding macros
+	compiling *lambda // the lambda whose body this is, if it is one
+	scope     *scope
+	code      *code
+}
+
+// compiles o to run in e. tail is whether calls in tail position replace
+// the frame the code runs in.
+func (i *Interpreter) compile(o Obj, e *Env, tail bool) *code {
+	c := &compiler{interp: i, env: e, code: &code{}}
+	c.compile(o, tail)
+	c.emit(opReturn, 0)
+	return c.code
+}
+
+// the compiled body of p, compiling it on the first call
+func (i *Interpreter) procCode(p *Procedure) *code {
+	if p.lambda == nil {
+		// made by Eval, so there's no lambda form to share code with
+		p.lambda = &lambda{args: p.args, variadic: p.variadic, body: p.body, env: p.scope}
+	}
+	return i.lambdaCode(p.lambda)
+}
+
+func (i *Interpreter) macroCode(m *Macro) *code {
+	if m.lambda == nil {
+		m.lambda = &lambda{args: m.args, variadic: m.variadic, body: m.body, env: m.scope}
+	}
+	return i.lambdaCode(m.lambda)
+}
+
+func (i *Interpreter) lambdaCode(l *lambda) *code {
+	if l.code != nil && i.syntaxUnchanged(l) {
+		return l.code
+	}
+	l.syntax, l.checked = nil, i.syntaxChanges
+	s := &scope{names: append([]Symbol{}, l.args...), parent: l.outer}
+	if l.variadic != nil {
+		s.names = append(s.names, *l.variadic)
+	}
+	// defines anywhere else in the body, like ones made by macros, bind
+	// variables by name when they run
+	body := listToSlice(l.body)
+	for _, expr := range body {
+		name := definedName(expr)
+		if name == nil {
+			continue
+		}
+		if !s.has(name) {
+			s.names = append(s.names, *name)
+		}
+	}
+	l.names = s.names
+	c := &compiler{interp: i, env: l.env, compiling: l, scope: s, code: &code{}}
+	c.body(body, true)
+	l.code = c.code
+	return l.code
+}
+
+// whether the heads l's code expanded as macros or compiled as special
+// forms are still bound to them. they're only looked up again after
+// something is bound to or from a macro or special form.
+func (i *Interpreter) syntaxUnchanged(l *lambda) bool {
+	if l.checked == i.syntaxChanges {
+		return true
+	}
+	for _, use := range l.syntax {
+		value, _ := l.env.lookup(use.sym)
+		switch want := use.value.(type) {
+		case *Macro:
+			if value != Obj(want) {
+				return false
+			}
+		case Primitive:
+			// special forms are compiled by name
+			if _, ok := value.(Primitive); !ok {
+				return false
+			}
+		}
+	}
+	l.checked = i.syntaxChanges
+	return true
+}
+
+// the variable bound by a (define name value) form, or nil
+func definedName(o Obj) *Symbol {
+	pair, ok := o.(*Pair)
+	if !ok {
+		return nil
+	}
+	head, ok := pair.Car.(*Symbol)
+	if !ok || *head.s != "define" {
+		return nil
+	}
+	args, ok := pair.Cdr.(*Pair)
+	if !ok {
+		return nil
+	}
+	name, _ := args.Car.(*Symbol)
+	return name
+}
+
+func (c *compiler) emit(op opcode, arg int) int {
+	c.code.instrs = append(c.code.instrs, makeInstr(op, arg))
+	return len(c.code.instrs) - 1
+}
+
+// points the jump at pc to the next instruction
+func (c *compiler) patch(pc int) {
+	c.code.instrs[pc] = makeInstr(c.code.instrs[pc].op(), len(c.code.instrs))
+}
+
+func (c *compiler) constant(o Obj) int {
+	c.code.consts = append(c.code.consts, o)
+	return len(c.code.consts) - 1
+}
+
+// compiles a sequence of expressions, returning the value of the last
+func (c *compiler) body(exprs []Obj, tail bool) {
+	if len(exprs) == 0 {
+		c.emit(opConst, c.constant(Nil))
+	}
+	for n, expr := range exprs {
+		if n < len(exprs)-1 {
+			c.compile(expr, false)
+			c.emit(opPop, 0)
+		} else {
+			c.compile(expr, tail)
+		}
+	}
+	c.emit(opReturn, 0)
+}
+
+// tail is whether o is in tail position, where calls replace the current
+// frame
+func (c *compiler) compile(o Obj, tail bool) {
+	switch o := o.(type) {
+	case *Symbol:
+		if l, ok := c.local(o); ok {
+			c.emit(opLoadLocal, l)
+		} else {
+			c.emit(opLoad, c.constant(o))
+		}
+	case *Pair:
+		c.compilePair(o, tail)
+	case Primitive, *Builtin, *Procedure, *Macro, *Number, *String, *Error, *Boolean, *EmptyList, *Vector, *HashTable, *Char, *Thread, *Channel, *Mutex:
+		c.emit(opConst, c.constant(o))
+	default:
+		c.emit(opRaise, c.constant(MakeError(TypeErrorSym, fmt.Sprintf("unknown object %#v passed to eval", o))))
+	}
+}
+
+// a form that can't be compiled, because it's malformed or because
+// expanding a macro in it raised, compiles to code raising the same thing,
+// so that it's only raised if the code runs like with Eval
+func (c *compiler) compilePair(form *Pair, tail bool) {
+	i := c.interp
+	start := len(c.code.instrs)
+	depth := len(i.stack)
+	defer func() {
+		r := recover()
+		if r == nil {
+			return
+		}
+		var condition Obj
+		switch r := r.(type) {
+		case *Error:
+			if r.Form == nil && len(i.stack) > depth {
+				r.Form = i.currentForm()
+			} else if r.Form == nil {
+				r.Form = form
+			}
+			condition = r
+		case *Raised:
+			condition = r.Value
+		default:
+			panic(r)
+		}
+		i.stack = i.stack[:depth]
+		c.code.instrs = c.code.instrs[:start]
+		c.emit(opRaise, c.constant(condition))
+	}()
+
+	head, ok := form.Car.(*Symbol)
+	if !ok || c.isLocal(head) {
+		c.call(form, tail, false)
+		return
+	}
+	switch value, _ := c.env.lookup(head); value := value.(type) {
+	case *Macro:
+		c.uses(head, value)
+		c.compile(i.expand(value, form, c.env), tail)
+	case Primitive:
+		c.uses(head, value)
+		if !c.specialForm(*head.s, form, tail) {
+			c.emit(opEval, c.constant(form))
+		}
+	default:
+		c.call(form, tail, true)
+	}
+}
+
+// notes that the code depends on sym being the macro or special form value
+func (c *compiler) uses(sym *Symbol, value Obj) {
+	if c.compiling != nil {
+		c.compiling.syntax = append(c.compiling.syntax, syntaxUse{sym: sym, value: value})
+	}
+}
+
+// whether sym is bound by a lambda around the code, or in the environment
+// it's compiled for other than the global one
+func (c *compiler) isLocal(sym *Symbol) bool {
+	if _, _, ok := c.scope.lookup(sym); ok {
+		return true
+	}
+	for e := c.env; e != nil && e != c.interp.global; e = e.parent {
+		if _, ok := e.bindings[*sym]; ok || e.slot(sym) >= 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// adds sym to the locals of the code if a lambda around it binds it,
+// returning its index
+func (c *compiler) local(sym *Symbol) (int, bool) {
+	depth, index, ok := c.scope.lookup(sym)
+	if !ok {
+		return 0, false
+	}
+	c.code.locals = append(c.code.locals, local{sym: sym, depth: depth, index: index})
+	return len(c.code.locals) - 1, true
+}
+
+// expand applies a macro to the unevaluated arguments in form on the VM
+func (i *Interpreter) expand(m *Macro, form *Pair, e *Env) Obj {
+	i.stack = append(i.stack, frame{form: form, proc: m})
+	i.tick()
+	var expansion Obj
+	if m.rules != nil {
+		expansion = m.expandRules(form.Cdr)
+	} else {
+		// the body's calls get frames of their own, like ApplyMacro's
+		// calls to Eval
+		expansion = i.run(i.macroCode(m), bindSlots("macro", m.lambda, listToSlice(form.Cdr), m.scope), len(i.stack))
+	}
+	i.stack = i.stack[:len(i.stack)-1]
+	return expansion
+}
+
+// checkHead is set if the head is a global variable, which could be a
+// macro by the time the code runs
+func (c *compiler) call(form *Pair, tail, checkHead bool) {
+	site := len(c.code.calls)
+	c.code.calls = append(c.code.calls, callSite{form: form})
+	if checkHead {
+		c.emit(opHead, site)
+	} else {
+		c.compile(form.Car, false)
+	}
+	if tail {
+		c.emit(opTailFrame, site)
+	} else {
+		c.emit(opFrame, site)
+	}
+	nargs := 0
+	for args := form.Cdr; !Nil.Equal(args); nargs++ {
+		pair, ok := args.(*Pair)
+		if !ok {
+			panic(MakeError(SyntaxErrorSym, "evlis called on a non-list object", args))
+		}
+		c.compile(pair.Car, false)
+		args = pair.Cdr
+	}
+	if tail {
+		c.emit(opTailCall, site)
+	} else {
+		c.emit(opCall, site)
+	}
+	c.code.calls[site].nargs = nargs
+	c.code.calls[site].end = len(c.code.instrs)
+}
+
+// compiles the special forms that have a Primitive bound to their name,
+// returning false if it doesn't know how to
+func (c *compiler) specialForm(name string, form *Pair, tail bool) bool {
+	switch name {
+	case "quote":
+		c.quote(form)
+	case "if":
+		c.ifForm(form, tail)
+	case "cond":
+		c.cond(form, tail)
+	case "define":
+		c.define(form)
+	case "set!":
+		c.set(form)
+	case "lambda":
+		c.lambda(form)
+	case "quasiquote":
+		c.quasiquoteForm(form)
+	case "guard":
+		c.guard(form)
+	default:
+		return false
+	}
+	return true
+}
+
+func (c *compiler) quote(form *Pair) {
+	args := listToSlice(form.Cdr)
+	if len(args) != 1 {
+		panic(MakeError(SyntaxErrorSym, "quote takes 1 argument"))
+	}
+	c.emit(opConst, c.constant(args[0]))
+}
+
+func (c *compiler) ifForm(form *Pair, tail bool) {
+	args := listToSlice(form.Cdr)
+	if len(args) != 2 && len(args) != 3 {
+		panic(MakeError(SyntaxErrorSym, "if takes 2 or 3 arguments", form.Cdr))
+	}
+	c.compile(args[0], false)
+	toElse := c.emit(opJumpIfFalse, 0)
+	c.compile(args[1], tail)
+	toEnd := c.emit(opJump, 0)
+	c.patch(toElse)
+	if len(args) == 3 {
+		c.compile(args[2], tail)
+	} else {
+		c.emit(opConst, c.constant(Nil))
+	}
+	c.patch(toEnd)
+}
+
+// a malformed clause is only raised if it's reached, like in CondPrim
+func (c *compiler) cond(form *Pair, tail bool) {
+	args := listToSlice(form.Cdr)
+	if len(args) < 1 {
+		panic(MakeError(SyntaxErrorSym, "cond takes at least 1 argument"))
+	}
+	toEnd := []int{}
+	done := false
+	for _, clause := range args {
+		clause, ok := properList(clause)
+		if !ok || len(clause) != 2 {
+			err := MakeError(SyntaxErrorSym, "each cond case should have a predicate and a body")
+			err.Form = form
+			c.emit(opRaise, c.constant(err))
+			done = true
+			break
+		}
+		pred, body := clause[0], clause[1]
+		if isElse(pred) {
+			c.compile(body, tail)
+			done = true
+			break
+		}
+		c.compile(pred, false)
+		next := c.emit(opJumpIfFalse, 0)
+		c.compile(body, tail)
+		toEnd = append(toEnd, c.emit(opJump, 0))
+		c.patch(next)
+	}
+	if !done {
+		c.emit(opConst, c.constant(Nil))
+	}
+	for _, pc := range toEnd {
+		c.patch(pc)
+	}
+}
+
+// like listToSlice, but returning false for improper lists
+func properList(o Obj) ([]Obj, bool) {
+	slice, rest := improperListToSlice(o)
+	return slice, rest == nil
+}
+
+func (c *compiler) define(form *Pair) {
+	args := listToSlice(form.Cdr)
+	if len(args) != 2 {
+		panic(MakeError(SyntaxErrorSym, "define takes 2 arguments"))
+	}
+	name, ok := args[0].(*Symbol)
+	if !ok {
+		panic(MakeError(SyntaxErrorSym, "the first argument to define is a symbol", args[0]))
+	}
+	c.compile(args[1], false)
+	if l, ok := c.local(name); ok && c.code.locals[l].depth == 0 {
+		c.emit(opDefineLocal, l)
+	} else {
+		c.emit(opDefine, c.constant(name))
+	}
+}
+
+func (c *compiler) set(form *Pair) {
+	args := listToSlice(form.Cdr)
+	if len(args) != 2 {
+		panic(MakeError(SyntaxErrorSym, "set takes 2 arguments"))
+	}
+	name, ok := args[0].(*Symbol)
+	if !ok {
+		panic(MakeError(SyntaxErrorSym, "the first argument to set is a symbol", args[0]))
+	}
+	c.compile(args[1], false)
+	if l, ok := c.local(name); ok {
+		c.emit(opSetLocal, l)
+	} else {
+		c.emit(opSet, c.constant(name))
+	}
+}
+
+func (c *compiler) lambda(form *Pair) {
+	formArgs := listToSlice(form.Cdr)
+	if len(formArgs) < 2 {
+		panic(MakeError(SyntaxErrorSym, "lambda takes at least 2 arguments"))
+	}
+	args, variadic := parseArgs(formArgs[0])
+	c.code.lambdas = append(c.code.lambdas, &lambda{
+		args:     args,
+		variadic: variadic,
+		body:     sliceToList(formArgs[1:]),
+		outer:    c.scope,
+		env:      c.env,
+	})
+	c.emit(opLambda, len(c.code.lambdas)-1)
+}
+
+func (c *compiler) quasiquoteForm(form *Pair) {
+	args := listToSlice(form.Cdr)
+	if len(args) != 1 {
+		panic(MakeError(SyntaxErrorSym, "quasiquote takes 1 argument"))
+	}
+	c.quasiquote(args[0], form)
+}
+
+// compiles what Quasiquote does into a call that builds the list, with
+// form as the call for backtraces
+func (c *compiler) quasiquote(o Obj, form *Pair) {
+	pair, ok := o.(*Pair)
+	if !ok {
+		if UnquoteSym.Equal(o) {
+			panic(MakeError(SyntaxErrorSym, "unquote takes 2 args"))
+		}
+		c.emit(opConst, c.constant(o))
+		return
+	}
+	elems := listToSlice(pair)
+	if len(elems) == 2 && UnquoteSym.Equal(elems[0]) {
+		c.compile(elems[1], false)
+		return
+	}
+
+	spliced := make([]bool, len(elems))
+	site := len(c.code.calls)
+	c.code.calls = append(c.code.calls, callSite{form: form, nargs: len(elems), syntax: QuasiquotePrim})
+	c.emit(opConst, c.constant(quasiquoteList(spliced)))
+	for n, elem := range elems {
+		if isUnquoteSplicing(elem) {
+			splicing := listToSlice(elem)
+			if len(splicing) != 2 {
+				panic(MakeError(SyntaxErrorSym, "unquote-splicing takes 2 args"))
+			}
+			spliced[n] = true
+			c.compile(splicing[1], false)
+		} else {
+			c.quasiquote(elem, form)
+		}
+	}
+	// the frame is only for errors building the list
+	c.emit(opFrame, site)
+	c.emit(opCall, site)
+	c.code.calls[site].end = len(c.code.instrs)
+}
+
+// makes a list of its arguments, splicing in the ones marked in spliced
+func quasiquoteList(spliced []bool) *Builtin {
+	return MakeBuiltin(func(args []Obj, e *Env) Obj {
+		out := make([]Obj, 0, len(args))
+		for n, arg := range args {
+			if spliced[n] {
+				out = append(out, listToSlice(arg)...)
+			} else {
+				out = append(out, arg)
+			}
+		}
+		e.interp.allocate(len(out))
+		return sliceToList(out)
+	})
+}
+
+// (guard (var clause ...) body ...), see GuardPrim
+func (c *compiler) guard(form *Pair) {
+	args := listToSlice(form.Cdr)
+	if len(args) < 2 {
+		panic(MakeError(SyntaxErrorSym, "guard takes at least 2 arguments"))
+	}
+	spec := listToSlice(args[0])
+	if len(spec) < 1 {
+		panic(MakeError(SyntaxErrorSym, "guard needs a variable to bind the condition to"))
+	}
+	name, ok := spec[0].(*Symbol)
+	if !ok {
+		panic(MakeError(SyntaxErrorSym, "guard variable must be a symbol", spec[0]))
+	}
+	clauses := make([][]Obj, 0, len(spec)-1)
+	for _, clause := range spec[1:] {
+		clause := listToSlice(clause)
+		if len(clause) < 1 {
+			panic(MakeError(SyntaxErrorSym, "each guard clause should have a test"))
+		}
+		if isElse(clause[0]) && len(clause) == 1 {
+			panic(MakeError(SyntaxErrorSym, "a guard else clause should have a body"))
+		}
+		clauses = append(clauses, clause)
+	}
+
+	// without tail calls, since runGuard runs them
+	body := &compiler{interp: c.interp, env: c.env, compiling: c.compiling, scope: c.scope, code: &code{}}
+	body.body(args[1:], false)
+
+	handlerScope := &scope{names: []Symbol{*name}, parent: c.scope}
+	handler := &compiler{interp: c.interp, env: c.env, compiling: c.compiling, scope: handlerScope, code: &code{}}
+	handler.guardClauses(clauses)
+
+	c.code.guards = append(c.code.guards, &guard{
+		name:    name,
+		names:   handlerScope.names,
+		body:    body.code,
+		handler: handler.code,
+	})
+	c.emit(opGuard, len(c.code.guards)-1)
+}
+
+// returns noClauseMatched if none of the clauses match
+func (c *compiler) guardClauses(clauses [][]Obj) {
+	for _, clause := range clauses {
+		test := clause[0]
+		if isElse(test) {
+			c.body(clause[1:], false)
+			return
+		}
+		c.compile(test, false)
+		if len(clause) == 1 {
+			// the value of the test is the result
+			c.emit(opDup, 0)
+			next := c.emit(opJumpIfFalse, 0)
+			c.emit(opReturn, 0)
+			c.patch(next)
+			c.emit(opPop, 0)
+			continue
+		}
+		next := c.emit(opJumpIfFalse, 0)
+		c.body(clause[1:], false)
+		c.patch(next)
+	}
+	c.emit(opConst, c.constant(noClauseMatched))
+	c.emit(opReturn, 0)
+}
@@ -0,0 +1,96 @@
+package lisp
+
+// ContinuationErrorSym is the kind of error raised by calling a
+// continuation that can't be resumed, or aborting to a prompt that isn't
+// there
+var ContinuationErrorSym = intern("continuation-error")
+
+// escape is where a continuation returns to, which is only there while the
+// call/cc that made it is running, and not suspended by abort-to-prompt
+type escape struct {
+	active bool
+	body   *body   // the prompt body the call/cc is in, nil at the top level
+	thread *Thread // the thread it's in, nil if it was called from Go
+}
+
+// jump carries the value passed to a continuation through a Go panic up to
+// the call/cc it came from
+type jump struct {
+	to    *escape
+	value Obj
+}
+
+// (call-with-current-continuation proc) calls proc with a continuation, a
+// procedure that makes call/cc return its argument straight away.
+// continuations only escape: they can be called until call/cc returns, to
+// exit early from loops and nested calls, but can't resume a computation
+// that has already finished.
+func CallCCPrim(args []Obj, e *Env) (result Obj) {
+	if len(args) != 1 {
+		panic(MakeError(ArityErrorSym, "call-with-current-continuation takes 1 argument"))
+	}
+	i := e.interp
+	here := &escape{active: true, body: i.currentBody(), thread: i.thread}
+	depth := len(i.stack)
+	k := MakeBuiltin(func(args []Obj, e *Env) Obj {
+		if len(args) != 1 {
+			panic(MakeError(ArityErrorSym, "continuation takes 1 argument"))
+		}
+		if !here.active {
+			panic(MakeError(ContinuationErrorSym, "continuation called after call/cc returned", args[0]))
+		}
+		if here.thread != i.thread {
+			panic(MakeError(ContinuationErrorSym, "continuation called from another thread", args[0]))
+		}
+		if !i.inside(here.body) {
+			panic(MakeError(ContinuationErrorSym, "continuation called while its call/cc is suspended", args[0]))
+		}
+		panic(&jump{to: here, value: args[0]})
+	})
+	defer func() {
+		here.active = false
+		r := recover()
+		if r == nil {
+			return
+		}
+		if j, ok := r.(*jump); ok && j.to == here {
+			i.stack = i.stack[:depth]
+			result = j.value
+			return
+		}
+		panic(r)
+	}()
+
+	return Call(args[0], []Obj{k}, e)
+}
+
+// (dynamic-wind before thunk after) calls before, thunk and after in turn
+// and returns what thunk returns. after is called when thunk is left by a
+// continuation or a raised condition too.
+func DynamicWindPrim(args []Obj, e *Env) Obj {
+	if len(args) != 3 {
+		panic(MakeError(ArityErrorSym, "dynamic-wind takes 3 arguments"))
+	}
+	before, thunk, after := args[0], args[1], args[2]
+	i := e.interp
+
+	Call(before, nil, e)
+	result := func() Obj {
+		defer func() {
+			r := recover()
+			if r == nil {
+				return
+			}
+			// the handlers see conditions before after runs
+			r = i.deliverRecovered(r, e)
+			_, escaping := r.(*jump)
+			if _, ok := i.conditionOf(r); ok || escaping {
+				Call(after, nil, e)
+			}
+			panic(r)
+		}()
+		return Call(thunk, nil, e)
+	}()
+	Call(after, nil, e)
+	return result
+}
@@ -0,0 +1,454 @@
+package lisp_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"lisp"
+)
+
+// what running some code did: its output, and its value or error
+type outcome struct {
+	output string
+	result string
+}
+
+// errors are compared with where they were raised and their backtraces
+func describeError(err error) string {
+	var lispErr *lisp.Error
+	if errors.As(err, &lispErr) {
+		return fmt.Sprintf("error %v: %v %v in %v\n%v", lispErr.Kind, lispErr.Message, lispErr.Irritants, lispErr.Form, err)
+	}
+	return err.Error()
+}
+
+func runWith(engine lisp.Engine, eval func(*lisp.Interpreter) (lisp.Obj, error), opts ...lisp.Option) outcome {
+	out := &bytes.Buffer{}
+	interp := lisp.New(append([]lisp.Option{lisp.WithEngine(engine), lisp.WithOutput(out)}, opts...)...)
+	result, err := eval(interp)
+	if err != nil {
+		return outcome{output: out.String(), result: describeError(err)}
+	}
+	return outcome{output: out.String(), result: fmt.Sprint(result)}
+}
+
+// returns what the tree engine did, for checking against what's expected
+func checkEngines(t *testing.T, eval func(*lisp.Interpreter) (lisp.Obj, error), opts ...lisp.Option) outcome {
+	t.Helper()
+	tree := runWith(lisp.TreeEngine, eval, opts...)
+	vm := runWith(lisp.VMEngine, eval, opts...)
+	if tree != vm {
+		t.Errorf("engines differ\ntree: %q\n  vm: %q", tree, vm)
+	}
+	return tree
+}
+
+func TestEnginesOnExamples(t *testing.T) {
+	paths, err := filepath.Glob("examples/*.lisp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) == 0 {
+		t.Fatal("no examples found")
+	}
+	for _, path := range paths {
+		path := path
+		t.Run(filepath.Base(path), func(t *testing.T) {
+			checkEngines(t, func(interp *lisp.Interpreter) (lisp.Obj, error) {
+				return interp.EvalFile(path)
+			})
+		})
+	}
+}
+
+var engineTests = []struct {
+	name string
+	src  string
+	// the output followed by the value or error
+	want string
+}{
+	{"tail loop", `
+(define loop (lambda (n acc) (if (= n 0) acc (loop (- n 1) (+ acc 1)))))
+(loop 100000 0)`, `100000`},
+	{"deep recursion", `
+(define count (lambda (n) (if (= n 0) 0 (+ 1 (count (- n 1))))))
+(count 10000)`, `10000`},
+	{"closures and set!", `
+(define make-counter (lambda () (define n 0) (lambda () (set! n (+ n 1)) n)))
+(define c (make-counter))
+(c) (c)
+(list (c) (set! c 5) c)`, `(3 #<procedure c: args=[] body=((set! n (+ n 1)) n) variadic=<nil>> 5)`},
+	{"use before define", `
+(define x 'global)
+(define f (lambda () (define a x) (define x 1) (list a x)))
+(f)`, `(global 1)`},
+	{"eval define shadows", `
+(define g (lambda (x) ((lambda () (eval '(define x 5)) x))))
+(g 1)`, `5`},
+	{"nested define", `(define k (lambda (y) (if y (define z 3)) (list y z))) (k 1)`, `(1 3)`},
+	{"set! outer variable", `
+(define s (lambda (n) (set! n (+ n 1)) ((lambda () (set! n (* n 10)))) n))
+(s 1)`, `20`},
+	{"duplicate arguments", `((lambda (x x) x) 1 2)`, `2`},
+	{"guard variable", `((lambda (q) (guard (e (#t (list e q))) (raise 7))) 2)`, `(7 2)`},
+	{"define from itself", `((lambda () (define w w) w))`, "error unbound-variable: tried to get unbound variable (w) in w\nunbound-variable: tried to get unbound variable w\n  in: w\nbacktrace:\n  ((lambda () (define w w) w)) at 1:1"},
+	{"variadic", `((lambda (a . rest) (list a rest)) 1 2 3)`, `(1 (2 3))`},
+	{"arity", `((lambda (a b) a) 1)`, "error arity-error: this procedure takes 2 arguments, but was given 1 () in ((lambda (a b) a) 1)\narity-error: this procedure takes 2 arguments, but was given 1\n  in: ((lambda (a b) a) 1) at 1:1"},
+	{"variadic arity", `((lambda (a b . c) a) 1)`, "error arity-error: this procedure takes 2 arguments, but was given 1 () in ((lambda (a b . c) a) 1)\narity-error: this procedure takes 2 arguments, but was given 1\n  in: ((lambda (a b . c) a) 1) at 1:1"},
+	{"apply", `(apply + (list 1 2 3))`, `6`},
+	{"apply circular list", `(define l (list 1 2)) (set-cdr! (cdr l) l) (apply + l)`, "error type-error: expected list, got circular list ((1 2 . #<cycle>)) in (apply + l)\ntype-error: expected list, got circular list (1 2 . #<cycle>)\n  in: (apply + l) at 1:44"},
+	{"apply tail loop", `
+(define loop (lambda (n) (if (= n 0) 'done (apply loop (list (- n 1))))))
+(loop 10000)`, `done`},
+	{"eval", `(define x 5) (eval (list '+ 'x 1))`, `6`},
+	{"eval sees locals", `((lambda (y) (eval 'y)) 7)`, `7`},
+	{"quasiquote", "(define x 2) (define xs '(3 4)) `(1 ,x ,@xs (5 ,(+ x 4)))", `(1 2 3 4 (5 6))`},
+	{"bad unquote-splicing", "`(1 (unquote-splicing))", "error syntax-error: unquote-splicing takes 2 args () in (quasiquote (1 (unquote-splicing)))\nsyntax-error: unquote-splicing takes 2 args\n  in: (quasiquote (1 (unquote-splicing))) at 1:1"},
+	{"cond", `(cond ((= 1 2) 'a) ((= 1 1) 'b) (else 'c))`, `b`},
+	{"cond else", `(cond ((= 1 2) 'a) (else 'c))`, `c`},
+	{"cond falls through", `(cond ((= 1 2) 'a))`, `()`},
+	{"cond bad clause not reached", `(cond (#t 'ok) (bad clause here))`, `ok`},
+	{"cond bad clause reached", `(cond (#f 'no) (bad clause here))`, "error syntax-error: each cond case should have a predicate and a body () in (cond (#f (quote no)) (bad clause here))\nsyntax-error: each cond case should have a predicate and a body\n  in: (cond (#f (quote no)) (bad clause here)) at 1:1"},
+	{"if without else", `(list (if #f 1) (if #t 1))`, `(() 1)`},
+	{"bad if not run", `(define f (lambda () (if))) 'fine`, `fine`},
+	{"bad if run", `(define f (lambda () (if))) (f)`, "error syntax-error: if takes 2 or 3 arguments (()) in (if)\nsyntax-error: if takes 2 or 3 arguments ()\n  in: (if) at 1:22\nbacktrace:\n  (f) at 1:29"},
+	{"macro", `(defmacro swap (a b) (list b a)) (swap 1 -)`, `-1`},
+	{"macro defined after use", `
+(define f (lambda (x) (twice x)))
+(defmacro twice (x) (list '+ x x))
+(f 4)`, `8`},
+	{"macro expanded once", `
+(define n 0)
+(defmacro counted (x) (set! n (+ n 1)) x)
+(define f (lambda (k) (if (= k 0) 'done (counted (f (- k 1))))))
+(list (f 5) (f 3) n)`, `(done done 1)`},
+	{"macro use changed in place", `
+(defmacro twice (x) (list '+ x x))
+(define form '(twice 1))
+(define nested '(twice (- 1 0)))
+(define before (list (eval form) (eval nested)))
+(set-car! (cdr form) 5)
+(set-car! (cdr (car (cdr nested))) 4)
+(list before (eval form) (eval nested))`, `((2 2) 10 8)`},
+	{"local shadows macro", `((lambda (let) (let 3)) (lambda (x) (* x 2)))`, `6`},
+	{"syntax-rules", `
+(define-syntax swap!
+  (syntax-rules () ((_ a b) (let ((tmp a)) (begin (set! a b) (set! b tmp))))))
+(define tmp 1)
+(define other 2)
+(swap! tmp other)
+(define-syntax my-list (syntax-rules () ((_ (a b ...) ...) '(a ... (b ... ...)))))
+(list tmp other (my-list (1 2 3) (4 5)) ((lambda (x) (or #f x)) 3))`, `(2 1 (1 4 (2 3 5)) 3)`},
+	{"syntax-rules hygiene", `
+(define-syntax first (syntax-rules () ((_ x) (car (list x)))))
+(list ((lambda (list car) (first 1)) 2 3) ((lambda (x) (let-syntax ((get (syntax-rules () ((_) x)))) ((lambda (x) (get)) 2))) 1))`, `(1 1)`},
+	{"syntax-rules literals", `
+(define-syntax my-if (syntax-rules (then else) ((_ c then t else e) (cond (c t) (else e)))))
+(list (my-if #f then 1 else 2) (letrec-syntax ((ev? (syntax-rules () ((_) #t) ((_ x . r) (od? . r)))) (od? (syntax-rules () ((_) #f) ((_ x . r) (ev? . r))))) (ev? 1 2 3)))`, `(2 #f)`},
+	{"syntax-rules no match", `(define-syntax one (syntax-rules () ((_ x) x))) (one 1 2)`, "error syntax-error: no syntax-rules pattern matches ((one 1 2)) in (one 1 2)\nsyntax-error: no syntax-rules pattern matches (one 1 2)\n  in: (one 1 2) at 1:49"},
+	{"begin and let", `(begin (define a 1) (let ((b 2) (c 3)) (+ a b c)))`, `6`},
+	{"or and not", `(list (or #f 2) (or #f #f) (not #f) (not 1))`, `(2 #f #t #f)`},
+	{"empty list is true", `(list (if '() 'true 'false) (not '()) (cond ('() 'true)))`, `(true #f true)`},
+	{"booleans", `(list (boolean? #f) (boolean? '()) (null? '()) (null? #f) (symbol? (= 1 1)) (eq? nil '()))`, `(#t #f #t #f #f #t)`},
+	{"map and filter", `(map (lambda (x) (* x x)) (filter (lambda (x) (< 2 x)) (list 1 2 3 4)))`, `(9 16)`},
+	{"define", `(define sq (lambda (x) (* x x))) (list (procedure? sq) (sq 3))`, `(#t 9)`},
+	{"guard", `(guard (e ((error-object? e) (error-object-message e))) (car 5))`, `"car takes pairs as arguments"`},
+	{"guard else", `(guard (e ((string? e) 'string) (else e)) (raise 'oops))`, `oops`},
+	{"guard test value", `(guard (e ((error-object-kind e))) (car 5))`, `type-error`},
+	{"guard re-raises", `(guard (e ((number? e) 'number)) (raise 'symbol))`, "uncaught raise: symbol\nbacktrace:\n  (raise (quote symbol)) at 1:34"},
+	{"guard bare else", `(guard (e (else)) (raise 1))`, "error syntax-error: a guard else clause should have a body () in (guard (e (else)) (raise 1))\nsyntax-error: a guard else clause should have a body\n  in: (guard (e (else)) (raise 1)) at 1:1"},
+	{"guard body defines", `(guard (e (#t e)) (define g 1)) g`, `1`},
+	{"with-exception-handler", `
+(with-exception-handler
+  (lambda (c) 10)
+  (lambda () (+ 1 (raise-continuable 'c))))`, `11`},
+	{"with-exception-handler returns from raise", `
+(with-exception-handler
+  (lambda (c) (list 'caught c))
+  (lambda () (+ 1 (raise 'c))))`, "error error: exception handler returned from non-continuable raise (c) in (raise (quote c))\nexception handler returned from non-continuable raise c\n  in: (raise (quote c)) at 4:19\nbacktrace:\n  (+ 1 (raise (quote c))) at 4:14\n  (#<procedure: args=[] body=((+ 1 (raise (quote c)))) vari...\n  (with-exception-handler (lambda (c) (list (quote caught) ... at 2:1"},
+	{"with-exception-handler in place", `
+(define log '())
+(define note (lambda (x) (set! log (cons x log))))
+(list (call/cc
+        (lambda (k)
+          (with-exception-handler
+            (lambda (c) (note 'handler) (k (error-object-kind c)))
+            (lambda () (dynamic-wind (lambda () #f) (lambda () (car 5)) (lambda () (note 'after)))))))
+      log
+      (guard (e ((error-object? e) (error-object-message e)))
+        (with-exception-handler (lambda (c) 'ignored) (lambda () (car 5))))
+      (guard (e (#t (list 'outer e)))
+        (with-exception-handler (lambda (c) (raise (list 'inner c))) (lambda () (raise 1))))
+      (call/cc
+        (lambda (k)
+          (with-exception-handler
+            (lambda (c) (k (list 'outer (error-object-kind c))))
+            (lambda () (with-exception-handler (lambda (c) (car c)) (lambda () (raise 'x))))))))`, `(type-error (after handler) "exception handler returned from non-continuable raise" (outer (inner 1)) (outer type-error))`},
+	{"call/cc", `(list (+ 1 (call/cc (lambda (k) 2))) (+ 1 (call/cc (lambda (k) (k 5) 2))) (call-with-current-continuation (lambda (k) (k (quote a)) (quote b))))`, `(3 6 a)`},
+	{"call/cc escapes nested map", `
+(call/cc
+  (lambda (return)
+    (map (lambda (row) (map (lambda (x) (if (< x 0) (return (list 'negative x)) x)) row))
+         '((1 2) (3 -4 5) (-6)))))`, `(negative -4)`},
+	{"call/cc escapes guard", `
+(guard (e (#t 'outer))
+  (list (call/cc (lambda (k) (guard (e (#t 'inner)) (vector-map (lambda (x) (k x)) #(1 2)))))))`, `(1)`},
+	{"continuation after return", `(define saved #f) (call/cc (lambda (k) (set! saved k))) (saved 1)`, "error continuation-error: continuation called after call/cc returned (1) in (saved 1)\ncontinuation-error: continuation called after call/cc returned 1\n  in: (saved 1) at 1:57"},
+	{"dynamic-wind", `
+(define trace '())
+(define note (lambda (x) (set! trace (cons x trace))))
+(define wind (lambda (body) (dynamic-wind (lambda () (note 'before)) body (lambda () (note 'after)))))
+(list (wind (lambda () 1))
+      (call/cc (lambda (k) (wind (lambda () (k 2) (note 'not-reached)))))
+      (guard (e (#t e)) (wind (lambda () (raise 3))))
+      trace)`, `(1 2 3 (after before after before after before))`},
+	{"prompts", `
+(list (call-with-prompt 'p (lambda () (+ 1 (abort-to-prompt 'p 10))) (lambda (k v) (list 'aborted v)))
+      (call-with-prompt 'p (lambda () (+ 1 (abort-to-prompt 'p 10))) (lambda (k v) (k (* v 2))))
+      (call-with-prompt 'outer
+        (lambda () (call-with-prompt 'inner (lambda () (list 'in (abort-to-prompt 'outer 1))) (lambda (k) 'inner)))
+        (lambda (k v) (list v (k 5)))))`, `((aborted 10) 21 (1 (in 5)))`},
+	{"prompt generator", `
+(define make-gen
+  (lambda (items)
+    (define next
+      (lambda ()
+        (call-with-prompt 'gen
+          (lambda () (map (lambda (x) (abort-to-prompt 'gen x)) items) 'done)
+          (lambda (k x) (set! next (lambda () (k #f))) x))))
+    (lambda () (next))))
+(define g (make-gen '(a b c)))
+(list (g) (g) (g) (g))`, `(a b c done)`},
+	{"prompts and errors", `
+(define message (lambda (thunk) (guard (e ((error-object? e) (error-object-message e)) (#t (list 'raised e))) (thunk))))
+(list (message (lambda () (call-with-prompt 'p (lambda () (abort-to-prompt 'p) (raise 'late)) (lambda (k) (k)))))
+      (message (lambda () (call-with-prompt 'p (lambda () (abort-to-prompt 'p)) (lambda (k) (k) (k)))))
+      (call/cc (lambda (out) (call-with-prompt 'p (lambda () (out 'escaped)) (lambda (k) 'handler))))
+      (message (lambda () (call-with-prompt 'p (lambda () (call/cc (lambda (c) (abort-to-prompt 'p c)))) (lambda (k c) (c 1))))))`, `((raised late) "continuation already resumed" escaped "continuation called while its call/cc is suspended")`},
+	{"no prompt", `(abort-to-prompt 'nope 1)`, "error continuation-error: no prompt with tag (nope) in (abort-to-prompt (quote nope) 1)\ncontinuation-error: no prompt with tag nope\n  in: (abort-to-prompt (quote nope) 1) at 1:1"},
+	{"threads and channels", `
+(define c (make-channel))
+(define workers (map (lambda (n) (spawn (lambda () (channel-send c (* n n)) n))) '(1 2 3 4)))
+(define sum (lambda (k acc) (if (= k 0) acc (sum (- k 1) (+ acc (channel-receive c))))))
+(define a (make-channel 1))
+(define b (make-channel 1))
+(channel-send b 'hello)
+(list (sum 4 0) (map join workers) (channel-select a b) (car (channel-select (list a 'sent))) (channel-receive a))`, `(30 (1 2 3 4) (#<channel> . hello) #<channel> sent)`},
+	{"mutexes", `
+(define m (make-mutex))
+(define counter 0)
+(define bump
+  (lambda (k)
+    (if (= k 0) 'done (begin (mutex-lock! m) (set! counter (+ counter 1)) (mutex-unlock! m) (bump (- k 1))))))
+(list (map join (map (lambda (n) (spawn (lambda () (bump 5000)))) '(1 2 3))) counter)`, `((done done done) 15000)`},
+	{"thread errors", `
+(list (guard (e (#t (list 'caught e))) (join (spawn (lambda () (raise 'oops)))))
+      (guard (e ((error-object? e) (error-object-message e))) (call/cc (lambda (k) (join (spawn (lambda () (k 1))))))))`, `((caught oops) "continuation called from another thread")`},
+	{"unlock unlocked mutex", `(mutex-unlock! (make-mutex))`, "error error: mutex-unlock! on a mutex that isn't locked (#<mutex>) in (mutex-unlock! (make-mutex))\nmutex-unlock! on a mutex that isn't locked #<mutex>\n  in: (mutex-unlock! (make-mutex)) at 1:1"},
+	{"error", `(error "bad thing:" 1 2)`, "error error: bad thing: (1 2) in (error \"bad thing:\" 1 2)\nbad thing: 1 2\n  in: (error \"bad thing:\" 1 2) at 1:1"},
+	{"unbound", `(+ 1 undefined-variable)`, "error unbound-variable: tried to get unbound variable (undefined-variable) in undefined-variable\nunbound-variable: tried to get unbound variable undefined-variable\n  in: undefined-variable\nbacktrace:\n  (+ 1 undefined-variable) at 1:1"},
+	{"set! unbound", `(set! undefined-variable 1)`, "error unbound-variable: tried to set unbound variable (undefined-variable) in undefined-variable\nunbound-variable: tried to set unbound variable undefined-variable\n  in: undefined-variable"},
+	{"not a procedure", `(1 2 3)`, "error type-error: tried to apply a non-procedure (1) in (1 2 3)\ntype-error: tried to apply a non-procedure 1\n  in: (1 2 3) at 1:1"},
+	{"improper call", `(+ 1 . 2)`, "error syntax-error: evlis called on a non-list object (2) in (+ 1 . 2)\nsyntax-error: evlis called on a non-list object 2\n  in: (+ 1 . 2) at 1:1"},
+	{"division by zero", `(/ 1 0)`, "error arithmetic-error: division by zero (1) in (/ 1 0)\narithmetic-error: division by zero 1\n  in: (/ 1 0) at 1:1"},
+	{"strings", `(string-append (symbol->string 'abc) "-" (number->string 42))`, `"abc-42"`},
+	{"vectors", `
+(define v (make-vector 3 0))
+(vector-set! v 1 'x)
+(list v #(1 (2 "three") #t) (vector-ref #(a b c) 2) (vector-length (vector)) (vector->list #(1 2 3) 1))`, `(#(0 x 0) #(1 (2 "three") #t) c 0 (2 3))`},
+	{"vector library", `
+(define v (list->vector '(1 2 3)))
+(vector-for-each (lambda (x) (display x)) v)
+(list (vector-map + v #(10 20)) (vector-fill! v 'z 2) (vector? v) (vector? '(1)))`, `123(#(11 22) #(1 2 z) #t #f)`},
+	{"vector index out of range", `(vector-ref #(1 2) 2)`, "error range-error: vector-ref index out of range (2) in (vector-ref #(1 2) 2)\nrange-error: vector-ref index out of range 2\n  in: (vector-ref #(1 2) 2) at 1:1"},
+	{"vector-set! literal", `(define f (lambda () #(1 2))) (vector-set! (f) 0 'a) (f)`, `#(a 2)`},
+	{"hash tables", `
+(define t (make-hash-table))
+(hash-table-set! t '(1 "two") 'list)
+(hash-table-set! t 1 'exact)
+(hash-table-set! t 1.0 'inexact)
+(hash-table-update! t 'n (lambda (x) (+ x 1)) (lambda () 0))
+(hash-table-delete! t 1)
+(list (hash-table-ref t (list 1 "two")) (hash-table-ref t 1 (lambda () 'missing)) (hash-table-keys t) t)`, `(list missing ((1 "two") 1.0 n) #hash(((1 "two") . list) (1.0 . inexact) (n . 1)))`},
+	{"hash table tests", `
+(define q (make-hash-table 'eq?))
+(hash-table-set! q "s" 1)
+(hash-table-set! q car 2)
+(list (hash-table-contains? q "s") (hash-table-ref q car) (hash-table->alist #hasheqv((a . 1) (2 . b))) (eq? car car))`, `(#f 2 ((a . 1) (2 . b)) #t)`},
+	{"hash table missing key", `(hash-table-ref (make-hash-table) 'k)`, "error range-error: hash-table-ref key not found (k) in (hash-table-ref (make-hash-table) (quote k))\nrange-error: hash-table-ref key not found k\n  in: (hash-table-ref (make-hash-table) (quote k)) at 1:1"},
+	{"characters", `
+(write #\a) (display #\a) (write #\space) (display #\x41)
+(list #\( #\newline #\x3bb (char->integer #\A) (integer->char 97) (char-upcase #\a) (char-numeric? #\5) (char<? #\a #\b #\a) (eq? #\a #\a))`, `#\aa#\spaceA(#\( #\newline #\λ 65 #\a #\A #t #f #t)`},
+	{"bad character", `(integer->char -1)`, "error range-error: integer->char takes a unicode scalar value (-1) in (integer->char -1)\nrange-error: integer->char takes a unicode scalar value -1\n  in: (integer->char -1) at 1:1"},
+	{"equality", `(list (eq? '(1) '(1)) (eqv? 1.0 1.0) (eqv? "a" "a") (equal? '(1 #(2 "three")) (list 1 (vector 2 "three"))) (equal? 1 1.0))`, `(#f #t #f #t #f)`},
+	{"equal? on cycles", `
+(define a (list 1 2))
+(set-cdr! (cdr a) a)
+(define b (list 1 2 1 2))
+(set-cdr! (cdr (cdr (cdr b))) b)
+(define c (list 1 2 1 3))
+(set-cdr! (cdr (cdr (cdr c))) c)
+(list (equal? a b) (equal? a c))`, `(#t #f)`},
+	{"member and assoc", `(list (member '(2) '(1 (2) 3)) (memq 'x '(a b)) (member 2.0 '(1 2 3) =) (assoc "b" '(("a" . 1) ("b" . 2))) (assv 2 '((1 . a) (2 . b))))`, `(((2) 3) #f (2 3) ("b" . 2) (2 . b))`},
+	{"display", `(display "hi") (newline) (print '(1 "two"))`, "hi\n(1 \"two\")\n()"},
+	{"macroexpand", `(macroexpand (let ((a 1)) a))`, `((lambda (a) (let () a)) 1)`},
+	{"macroexpand-1", `(list (macroexpand-1 (or a b)) (macroexpand (or a b)) (macroexpand-1 (f x)))`, `((let ((x a)) (if x x (or b))) ((lambda (x) (let () (if x x (or b)))) a) (f x))`},
+	{"macroexpand-all", `
+(list (macroexpand-all (let ((a 1) (b 2)) (+ a b)))
+      (macroexpand-all (lambda (or) (or 1 2) '(or 3 4)))
+      (macroexpand-all (cond ((or a b) 1) (else (or c d))))
+      (macroexpand-all (quasiquote (1 (unquote (or a b)) (or c d)))))`, `(((lambda (a) ((lambda (b) (+ a b)) 2)) 1) (lambda (or) (or 1 2) (quote (or 3 4))) (cond (((lambda (x) (if x x b)) a) 1) (else ((lambda (x) (if x x d)) c))) (quasiquote (1 (unquote ((lambda (x) (if x x b)) a)) (or c d))))`},
+	{"special form as value", `(define my-if if) (my-if #t 1 2)`, `1`},
+	{"exit", `(exit 3)`, `exit status 3`},
+}
+
+func TestEngines(t *testing.T) {
+	for _, test := range engineTests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			got := checkEngines(t, func(interp *lisp.Interpreter) (lisp.Obj, error) {
+				return interp.EvalString(test.src)
+			})
+			if got.output+got.result != test.want {
+				t.Errorf("got %q, want %q", got.output+got.result, test.want)
+			}
+		})
+	}
+}
+
+// code written when nil was false should still work with WithNilAsFalse
+func TestNilAsFalse(t *testing.T) {
+	src := `
+(define any (lambda (f ls) (if ls (if (f (car ls)) #t (any f (cdr ls))))))
+(list (any (lambda (x) (= x 2)) '(1 2 3)) (any (lambda (x) (= x 5)) '(1 2 3)) (if nil 1 2) (not '()))`
+	eval := func(interp *lisp.Interpreter) (lisp.Obj, error) {
+		return interp.EvalString(src)
+	}
+	checkEngines(t, eval, lisp.WithNilAsFalse())
+	if got := runWith(lisp.VMEngine, eval, lisp.WithNilAsFalse()).result; got != "(#t () 2 #t)" {
+		t.Errorf("got %v, want (#t () 2 #t)", got)
+	}
+}
+
+// runaway code raises a limit-error instead of hanging or crashing
+func TestLimits(t *testing.T) {
+	tests := []struct {
+		name   string
+		limits lisp.Limits
+		src    string
+	}{
+		{"steps", lisp.Limits{Steps: 10000}, `(define loop (lambda () (loop))) (loop)`},
+		{"depth", lisp.Limits{Depth: 1000}, `(define count (lambda (n) (if (= n 0) 0 (+ 1 (count (- n 1)))))) (count 100000)`},
+		{"conses", lisp.Limits{Conses: 1000}, `(define grow (lambda (l) (grow (cons 1 l)))) (grow '())`},
+		{"conses from list", lisp.Limits{Conses: 1000}, `(define grow (lambda (l) (grow (list l l)))) (grow '())`},
+		{"conses from make-vector", lisp.Limits{Conses: 1000}, `(make-vector 1000000000)`},
+		{"timeout", lisp.Limits{Timeout: 20 * time.Millisecond}, `(define loop (lambda () (loop))) (loop)`},
+		{"timeout while waiting", lisp.Limits{Timeout: 20 * time.Millisecond}, `(channel-receive (make-channel))`},
+		{"timeout in thread", lisp.Limits{Timeout: 20 * time.Millisecond}, `(join (spawn (lambda () (define loop (lambda () (loop))) (loop))))`},
+		{"caught and carried on", lisp.Limits{Steps: 10000}, `
+(define loop (lambda () (loop)))
+(list (guard (e ((error-object? e) (error-object-message e))) (loop)) (+ 1 2))`},
+		{"under the limits", lisp.Limits{Steps: 10000, Depth: 100, Conses: 100, Timeout: time.Minute}, `(list 1 2 3)`},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			eval := func(interp *lisp.Interpreter) (lisp.Obj, error) {
+				return interp.EvalString(test.src)
+			}
+			checkEngines(t, eval, lisp.WithLimits(test.limits))
+			got := runWith(lisp.VMEngine, eval, lisp.WithLimits(test.limits)).result
+			if isError := strings.HasPrefix(got, "error limit-error"); isError != (test.name != "under the limits") {
+				t.Errorf("got %v", got)
+			}
+		})
+	}
+}
+
+// each call from Go gets the full limits, however much earlier ones used
+func TestLimitsPerCall(t *testing.T) {
+	interp := lisp.New(lisp.WithLimits(lisp.Limits{Steps: 1000}))
+	if _, err := interp.EvalString(`(define count (lambda (n) (if (= n 0) 'done (count (- n 1)))))`); err != nil {
+		t.Fatal(err)
+	}
+	for n := 0; n < 5; n++ {
+		if _, err := interp.Call("count", lisp.MakeInt(200)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestCancel(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+	}{
+		{"running", `(define loop (lambda () (loop))) (loop)`},
+		{"waiting", `(channel-receive (make-channel))`},
+		{"in thread", `(join (spawn (lambda () (define loop (lambda () (loop))) (loop))))`},
+		{"caught and carried on", `
+(define loop (lambda () (loop)))
+(guard (e (#t (loop))) (loop))`},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			eval := func(interp *lisp.Interpreter) (lisp.Obj, error) {
+				ctx, cancel := context.WithCancel(context.Background())
+				time.AfterFunc(20*time.Millisecond, cancel)
+				_, err := interp.EvalStringContext(ctx, test.src)
+				if !errors.Is(err, context.Canceled) {
+					return nil, fmt.Errorf("got %v", err)
+				}
+				// still usable afterwards
+				return interp.EvalString(`(+ 1 2)`)
+			}
+			checkEngines(t, eval)
+			if got := runWith(lisp.VMEngine, eval).result; got != "3" {
+				t.Errorf("got %v", got)
+			}
+		})
+	}
+}
+
+// a deadline on the context is a cancellation rather than a time limit
+func TestCancelDeadline(t *testing.T) {
+	interp := lisp.New(lisp.WithLimits(lisp.Limits{Timeout: time.Minute}))
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err := interp.EvalStringContext(ctx, `(define loop (lambda () (loop))) (loop)`)
+	var lispErr *lisp.Error
+	if !errors.As(err, &lispErr) || lispErr.Kind != lisp.CancelledErrorSym || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v", err)
+	}
+}
+
+func TestCancelBeforeCall(t *testing.T) {
+	interp := lisp.New()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := interp.CallContext(ctx, "list"); !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v", err)
+	}
+	if _, err := interp.EvalContext(ctx, lisp.MakeInt(1)); !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v", err)
+	}
+}
+
+func TestCallFromGo(t *testing.T) {
+	got := checkEngines(t, func(interp *lisp.Interpreter) (lisp.Obj, error) {
+		if _, err := interp.EvalString(`(define add (lambda (a b) (+ a b)))`); err != nil {
+			return nil, err
+		}
+		return interp.Call("add", lisp.List(lisp.MakeString("unused"))) // wrong arity
+	})
+	if want := "error arity-error: this procedure takes 2 arguments, but was given 1 "; !strings.HasPrefix(got.result, want) {
+		t.Errorf("got %q, want %q", got.result, want)
+	}
+}
@@ -0,0 +1,360 @@
+package lisp
+
+import (
+	"fmt"
+	"strings"
+)
+
+// error kinds, exposed to Lisp through error-object-kind
+var (
+	ErrorSym           = intern("error") // raised by the error procedure
+	SyntaxErrorSym     = intern("syntax-error")
+	ArityErrorSym      = intern("arity-error")
+	TypeErrorSym       = intern("type-error")
+	UnboundErrorSym    = intern("unbound-variable")
+	ArithmeticErrorSym = intern("arithmetic-error")
+	RangeErrorSym      = intern("range-error")
+	ReadErrorSym       = intern("read-error")
+)
+
+// Error is the condition object raised by primitives and by the error
+// procedure. Any other object can be raised too, see raisedObj.
+type Error struct {
+	Kind      *Symbol
+	Message   string
+	Irritants Obj     // a list of the values involved in the error
+	Form      Obj     // the form that was being applied, nil if unknown
+	Pos       Pos     // where Form was read, if it was
+	Backtrace []Frame // the call stack when the error was raised, innermost first
+
+	cause error // the Go error behind it, if any, see Unwrap
+}
+
+func (Error) Type() ObjType {
+	return TypeError
+}
+
+func MakeError(kind *Symbol, message string, irritants ...Obj) *Error {
+	return &Error{Kind: kind, Message: message, Irritants: sliceToList(irritants)}
+}
+
+// Unwrap returns the Go error that caused err, like the context's error for
+// a cancelled error, or nil
+func (err *Error) Unwrap() error {
+	return err.cause
+}
+
+// describes the error along with the form it happened in
+func (err *Error) Error() string {
+	b := strings.Builder{}
+	b.WriteString(err.summary())
+	if err.Form != nil {
+		b.WriteString("\n  in: ")
+		b.WriteString(mustStringer(err.Form).String())
+	}
+	if err.Pos.IsValid() {
+		if err.Form == nil {
+			b.WriteString("\n ")
+		}
+		b.WriteString(" at ")
+		b.WriteString(err.Pos.String())
+	}
+	// the innermost frame is left out when it's the form already shown
+	frames := err.Backtrace
+	skipFirst := len(frames) > 0 && frames[0].Form == err.Form
+	if len(frames) > 1 || len(frames) == 1 && (!skipFirst || frames[0].Elided) {
+		b.WriteString("\n")
+		b.WriteString(formatBacktrace(frames, skipFirst))
+	}
+	return b.String()
+}
+
+// Raised carries a non-error object passed to raise through a Go panic, so
+// that it can be told apart from interpreter bugs. It's also the error
+// returned when such an object isn't handled.
+type Raised struct {
+	Value     Obj
+	Backtrace []Frame
+}
+
+func (r *Raised) Error() string {
+	msg := "uncaught raise: " + mustStringer(r.Value).String()
+	if len(r.Backtrace) > 0 {
+		msg += "\n" + formatBacktrace(r.Backtrace, false)
+	}
+	return msg
+}
+
+// ExitError is returned when a program calls exit, so that embedding
+// programs aren't killed by it
+type ExitError struct {
+	Code int
+}
+
+func (err *ExitError) Error() string {
+	return fmt.Sprintf("exit status %v", err.Code)
+}
+
+// raise unwinds with a condition, for errors from Go. with-exception-handler
+// and dynamic-wind hand it to the handlers when they recover it, see
+// deliver.
+func raise(o Obj) {
+	if err, ok := o.(*Error); ok {
+		panic(err)
+	}
+	panic(&Raised{Value: o})
+}
+
+// signal is raise for the raise procedure, which calls the handlers
+// before unwinding
+func (i *Interpreter) signal(o Obj, e *Env) {
+	if err, ok := o.(*Error); ok {
+		panic(i.deliver(err, e))
+	}
+	panic(i.deliver(&Raised{Value: o}, e))
+}
+
+// deliver calls the handlers installed by with-exception-handler with a
+// condition, innermost first, each where the condition was raised with the
+// handlers outside it installed, like R7RS. A handler that returns raises
+// a secondary error for the next one. It stops at a guard, or when there
+// are no handlers left, and returns what to unwind to there with.
+func (i *Interpreter) deliver(r interface{}, e *Env) interface{} {
+	for {
+		n := len(i.handlers)
+		if n == 0 || i.handlers[n-1] == nil {
+			i.delivered = r
+			return r
+		}
+		handler := i.handlers[n-1]
+		i.handlers = i.handlers[:n-1]
+		condition, _ := i.conditionOf(r)
+		Call(handler, []Obj{condition}, e)
+		r = MakeError(ErrorSym, "exception handler returned from non-continuable raise", condition)
+	}
+}
+
+// like deliver, for a panic recovered on its way out. conditions from Go,
+// and ones raised by the handlers, are delivered until what's left is
+// unwinding to a guard or out of Lisp, or escaping with a continuation.
+func (i *Interpreter) deliverRecovered(r interface{}, e *Env) interface{} {
+	for {
+		if _, ok := i.conditionOf(r); !ok || r == i.delivered {
+			return r
+		}
+		r = func() (out interface{}) {
+			defer func() {
+				if escaped := recover(); escaped != nil {
+					out = escaped
+				}
+			}()
+			return i.deliver(r, e)
+		}()
+	}
+}
+
+// conditionOf returns the Lisp object raised by a recovered panic value, or
+// false if the panic didn't come from a Lisp condition. The first time a
+// condition is recovered it's filled in with where it was raised from.
+func (i *Interpreter) conditionOf(r interface{}) (Obj, bool) {
+	switch r := r.(type) {
+	case *Error:
+		if r.Form == nil {
+			r.Form = i.currentForm()
+		}
+		if !r.Pos.IsValid() {
+			r.Pos = i.posOf(r.Form)
+		}
+		// symbols have no position of their own, so errors from them
+		// have none, and the backtrace shows where they were
+		if r.Backtrace == nil {
+			r.Backtrace = i.backtrace()
+		}
+		return r, true
+	case *Raised:
+		if r.Backtrace == nil {
+			r.Backtrace = i.backtrace()
+		}
+		return r.Value, true
+	default:
+		return nil, false
+	}
+}
+
+// asError turns a condition back into the Go error that carried it
+func asError(condition Obj) error {
+	if err, ok := condition.(*Error); ok {
+		return err
+	}
+	return &Raised{Value: condition}
+}
+
+func ErrorPrim(args []Obj, e *Env) Obj {
+	if len(args) < 1 {
+		panic(MakeError(ArityErrorSym, "error takes at least 1 argument"))
+	}
+	e.interp.signal(MakeError(ErrorSym, displayString(args[0]), args[1:]...), e)
+	return Nil
+}
+
+func RaisePrim(args []Obj, e *Env) Obj {
+	if len(args) != 1 {
+		panic(MakeError(ArityErrorSym, "raise takes 1 argument"))
+	}
+	e.interp.signal(args[0], e)
+	return Nil
+}
+
+// calls the innermost handler without unwinding and returns its result
+func RaiseContinuablePrim(args []Obj, e *Env) Obj {
+	if len(args) != 1 {
+		panic(MakeError(ArityErrorSym, "raise-continuable takes 1 argument"))
+	}
+	i := e.interp
+	if len(i.handlers) == 0 || i.handlers[len(i.handlers)-1] == nil {
+		raise(args[0])
+	}
+
+	// the handler runs with the outer handlers installed
+	outer := i.handlers
+	handler := i.handlers[len(i.handlers)-1]
+	i.handlers = i.handlers[:len(i.handlers)-1]
+	defer func() { i.handlers = outer }()
+
+	return Call(handler, []Obj{args[0]}, e)
+}
+
+// (with-exception-handler handler thunk)
+//
+// calls thunk with handler installed. Conditions raised inside it call
+// handler where they're raised, with the outer handlers installed. For
+// raise-continuable its result is returned to the raise. For anything else
+// a handler that returns raises a secondary error, like R7RS, so to carry
+// on after an error a handler has to escape, say with a continuation.
+func WithExceptionHandlerPrim(args []Obj, e *Env) Obj {
+	if len(args) != 2 {
+		panic(MakeError(ArityErrorSym, "with-exception-handler takes 2 arguments"))
+	}
+	handler, thunk := args[0], args[1]
+
+	i := e.interp
+	outer := i.handlers
+	i.handlers = append(i.handlers[:len(i.handlers):len(i.handlers)], handler)
+	defer func() {
+		r := recover()
+		if r != nil {
+			// errors from Go have only unwound as far as here
+			r = i.deliverRecovered(r, e)
+		}
+		i.handlers = outer
+		if r != nil {
+			panic(r)
+		}
+	}()
+
+	return Call(thunk, nil, e)
+}
+
+// (guard (var clause ...) body ...)
+//
+// evaluates body, and if a condition is raised binds it to var and tries
+// each (test expr ...) clause like cond. the condition is re-raised if no
+// clause matches.
+func GuardPrim(o Obj, e *Env) (result Obj) {
+	args := listToSlice(o)
+	if len(args) < 2 {
+		panic(MakeError(SyntaxErrorSym, "guard takes at least 2 arguments"))
+	}
+	spec := listToSlice(args[0])
+	if len(spec) < 1 {
+		panic(MakeError(SyntaxErrorSym, "guard needs a variable to bind the condition to"))
+	}
+	name, ok := spec[0].(*Symbol)
+	if !ok {
+		panic(MakeError(SyntaxErrorSym, "guard variable must be a symbol", spec[0]))
+	}
+	clauses := make([][]Obj, 0, len(spec)-1)
+	for _, clause := range spec[1:] {
+		clause := listToSlice(clause)
+		if len(clause) < 1 {
+			panic(MakeError(SyntaxErrorSym, "each guard clause should have a test"))
+		}
+		if isElse(clause[0]) && len(clause) == 1 {
+			panic(MakeError(SyntaxErrorSym, "a guard else clause should have a body"))
+		}
+		clauses = append(clauses, clause)
+	}
+
+	// the handler runs without tail calls, like the body, since the VM
+	// runs it from here too
+	i := e.interp
+	outer := i.handlers
+	depth := len(i.stack)
+	i.handlers = append(i.handlers[:len(i.handlers):len(i.handlers)], nil)
+	defer func() {
+		i.handlers = outer
+		r := recover()
+		if r == nil {
+			return
+		}
+		condition, ok := i.conditionOf(r)
+		if !ok {
+			panic(r)
+		}
+		i.stack = i.stack[:depth]
+
+		scope := MakeEnv(e)
+		scope.Bind(name, condition)
+		for _, clause := range clauses {
+			test := clause[0]
+			if !isElse(test) {
+				test = Eval(test, scope)
+				if i.isFalse(test) {
+					continue
+				}
+			}
+			result = test
+			for _, expr := range clause[1:] {
+				result = Eval(expr, scope)
+			}
+			return
+		}
+		panic(i.deliver(r, e))
+	}()
+
+	for _, expr := range args[1:] {
+		result = Eval(expr, e)
+	}
+	return result
+}
+
+func IsErrorPrim(args []Obj, e *Env) Obj {
+	if len(args) != 1 {
+		panic(MakeError(ArityErrorSym, "error-object? takes 1 argument"))
+	}
+	_, ok := args[0].(*Error)
+	return boolToLisp(ok)
+}
+
+func errorArg(name string, args []Obj) *Error {
+	if len(args) != 1 {
+		panic(MakeError(ArityErrorSym, fmt.Sprintf("%v takes 1 argument", name)))
+	}
+	err, ok := args[0].(*Error)
+	if !ok {
+		panic(MakeError(TypeErrorSym, fmt.Sprintf("%v takes an error object", name), args[0]))
+	}
+	return err
+}
+
+func ErrorKindPrim(args []Obj, e *Env) Obj {
+	return errorArg("error-object-kind", args).Kind
+}
+
+func ErrorMessagePrim(args []Obj, e *Env) Obj {
+	return MakeString(errorArg("error-object-message", args).Message)
+}
+
+func ErrorIrritantsPrim(args []Obj, e *Env) Obj {
+	return errorArg("error-object-irritants", args).Irritants
+}
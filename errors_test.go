@@ -0,0 +1,65 @@
+package lisp
+
+import (
+	"fmt"
+	"testing"
+)
+
+// primitives and the error procedure raise error objects that say what went
+// wrong, with what, and where
+func TestErrorObjects(t *testing.T) {
+	tests := []struct {
+		src                            string
+		kind, message, irritants, form string
+	}{
+		{`(car 5)`, "type-error", "car takes pairs as arguments", "(5)", "(car 5)"},
+		{`(+ 1 undefined-var)`, "unbound-variable", "tried to get unbound variable", "(undefined-var)", "undefined-var"},
+		{`(error "bad thing:" 1 "two")`, "error", "bad thing:", `(1 "two")`, `(error "bad thing:" 1 "two")`},
+		{`((lambda (x) x))`, "arity-error", "this procedure takes 1 arguments, but was given 0", "()", "((lambda (x) x))"},
+	}
+	for _, test := range tests {
+		_, err := New().EvalString(test.src)
+		lispErr, ok := err.(*Error)
+		if !ok {
+			t.Errorf("%v: got %v, want an error object", test.src, err)
+			continue
+		}
+		got := [4]string{lispErr.Kind.String(), lispErr.Message, fmt.Sprint(lispErr.Irritants), fmt.Sprint(lispErr.Form)}
+		want := [4]string{test.kind, test.message, test.irritants, test.form}
+		if got != want {
+			t.Errorf("%v: got %q, want %q", test.src, got, want)
+		}
+	}
+}
+
+// anything else raised and not caught comes back as a Raised
+func TestRaisedObjects(t *testing.T) {
+	_, err := New().EvalString(`(guard (e ((string? e) 'string)) (raise 'not-a-string))`)
+	raised, ok := err.(*Raised)
+	if !ok || fmt.Sprint(raised.Value) != "not-a-string" {
+		t.Errorf("got %v, want not-a-string to be raised", err)
+	}
+}
+
+func TestHandlingErrors(t *testing.T) {
+	tests := []struct {
+		src, want string
+	}{
+		{`(guard (e ((error-object? e) (list (error-object-kind e) (error-object-message e) (error-object-irritants e)))) undefined-var)`,
+			`(unbound-variable "tried to get unbound variable" (undefined-var))`},
+		{`(guard (e ((string? e) 'string) ((symbol? e) (list 'caught e))) (raise 'oops))`, "(caught oops)"},
+		{`(guard (e ((error-object? e) (error-object-kind e))) (car 5) 'not-reached)`, "type-error"},
+		{`(with-exception-handler (lambda (c) (* c 2)) (lambda () (+ 1 (raise-continuable 20))))`, "41"},
+		{`(call/cc (lambda (k) (with-exception-handler (lambda (c) (k (list 'handled (error-object-message c)))) (lambda () (error "boom" 1)))))`, `(handled "boom")`},
+	}
+	for _, test := range tests {
+		result, err := New().EvalString(test.src)
+		if err != nil {
+			t.Errorf("%v: %v", test.src, err)
+			continue
+		}
+		if got := fmt.Sprint(result); got != test.want {
+			t.Errorf("%v: got %v, want %v", test.src, got, test.want)
+		}
+	}
+}
@@ -1,18 +1,155 @@
-package main
+package lisp
 
 import "fmt"
 
+// Eval runs in a loop so that expressions in tail position (the last
+// expression of a procedure body, the branches of if and cond, and macro
+// expansions) are evaluated without growing the Go stack
+//
+// The applications it makes are kept on the interpreter's call stack for
+// backtraces. A panic leaves them there, so that whoever recovers can see
+// where it came from.
 func Eval(o Obj, e *Env) Obj {
-	switch o := o.(type) {
-	case *Primitive, *Procedure, *Macro, *Number:
-		return o
-	case *Symbol:
-		return e.Resolve(o)
-	case *Pair:
-		return Apply(Eval(Car(o), e), Cdr(o), e)
-	default:
-		panic(fmt.Sprintf("unknown object %#v passed to eval", o))
+	i := e.interp
+	base := len(i.stack)
+	for {
+		switch obj := o.(type) {
+		case Primitive, *Builtin, *Procedure, *Macro, *Number, *String, *Error, *Boolean, *EmptyList, *Vector, *HashTable, *Char, *Thread, *Channel, *Mutex:
+			i.stack = i.stack[:base]
+			return obj
+		case *Symbol:
+			value := e.Resolve(obj)
+			i.stack = i.stack[:base]
+			return value
+		case *Pair:
+			proc := Eval(Car(obj), e)
+			var result Obj
+			switch proc := proc.(type) {
+			case *Macro:
+				// the expansion takes the place of the macro use, which
+				// only has a frame while it's being expanded
+				i.stack = append(i.stack, frame{form: obj, proc: proc})
+				o = i.expandOnce(proc, obj, e)
+				i.stack = i.stack[:len(i.stack)-1]
+				continue
+			case Primitive:
+				result = i.applySyntax(proc, obj, e)
+			default:
+				i.pushFrame(base, obj, proc)
+				i.tick()
+				result = Apply(proc, Cdr(obj), e)
+			}
+			tail, ok := result.(*TailCall)
+			if !ok {
+				i.stack = i.stack[:base]
+				return result
+			}
+			if tail.Proc != nil {
+				o, e = callForm(tail.Proc, tail.Args), tail.Env
+			} else {
+				o, e = tail.Expr, tail.Env
+			}
+		default:
+			panic(MakeError(TypeErrorSym, fmt.Sprintf("unknown object %#v passed to eval", obj)))
+		}
+	}
+}
+
+// applies a special form, which doesn't get a frame. errors it raises
+// itself, rather than ones from the code it evaluates, are raised from
+// form, like the VM raises them from the form it couldn't compile.
+func (i *Interpreter) applySyntax(prim Primitive, form *Pair, e *Env) Obj {
+	depth := len(i.stack)
+	defer func() {
+		if r := recover(); r != nil {
+			if err, ok := r.(*Error); ok && err.Form == nil && len(i.stack) == depth {
+				err.Form = form
+			}
+			panic(r)
+		}
+	}()
+	return prim(form.Cdr, e)
+}
+
+// the most expansions kept by expandOnce before it starts over, so that
+// forms built on the fly for eval don't pile up
+const maxExpansions = 1 << 16
+
+type cachedExpansion struct {
+	macro *Macro
+	form  Obj
+}
+
+// expandOnce applies a macro to the unevaluated arguments in form, reusing
+// the expansion from the last time form was evaluated if its head was the
+// same macro then, so that a macro use in a loop is only expanded once.
+// Changing any part of form in place throws the expansions away, see
+// changing.
+func (i *Interpreter) expandOnce(m *Macro, form *Pair, e *Env) Obj {
+	if cached, ok := i.expansions[form]; ok && cached.macro == m {
+		return cached.form
+	}
+	i.tick()
+	expansion := ApplyMacro(m, form.Cdr, e)
+	if len(i.expansions) >= maxExpansions {
+		i.forgetExpansions()
+	}
+	i.expansions[form] = cachedExpansion{macro: m, form: expansion}
+	i.markExpanded(form)
+	return expansion
+}
+
+// records the pairs and vectors that make up an expanded form
+func (i *Interpreter) markExpanded(o Obj) {
+	for {
+		switch obj := o.(type) {
+		case *Pair:
+			if i.expanded[obj] {
+				return
+			}
+			i.expanded[obj] = true
+			i.markExpanded(obj.Car)
+			o = obj.Cdr
+		case *Vector:
+			if i.expanded[obj] {
+				return
+			}
+			i.expanded[obj] = true
+			for _, item := range obj.items {
+				i.markExpanded(item)
+			}
+			return
+		default:
+			return
+		}
+	}
+}
+
+// called before a pair or vector is changed in place, since a macro use
+// it's part of might expand differently now
+func (i *Interpreter) changing(o Obj) {
+	if i.expanded[o] {
+		i.forgetExpansions()
+	}
+}
+
+func (i *Interpreter) forgetExpansions() {
+	i.expansions = map[*Pair]cachedExpansion{}
+	i.expanded = map[Obj]bool{}
+}
+
+// like Evlis, but into a slice for builtins
+func evalArgs(o Obj, e *Env) []Obj {
+	args := []Obj{}
+	for !Nil.Equal(o) {
+		pair, ok := o.(*Pair)
+		if !ok {
+			panic(MakeError(SyntaxErrorSym, "evlis called on a non-list object", o))
+		}
+		args = append(args, Eval(Car(pair), e))
+		o = Cdr(pair)
 	}
+	return args
 }
 
 func Evlis(o Obj, e *Env) Obj {
@@ -22,70 +159,100 @@ func Evlis(o Obj, e *Env) Obj {
 	if pair, ok := o.(*Pair); ok {
 		return Cons(Eval(Car(pair), e), Evlis(Cdr(pair), e))
 	}
-	panic("evlist called on a non-list object %#v")
+	panic(MakeError(SyntaxErrorSym, "evlis called on a non-list object", o))
 }
 
+// Apply may return a *TailCall instead of a value, which the caller must
+// evaluate (Eval does this for you)
 func Apply(proc Obj, args Obj, e *Env) Obj {
 	switch proc := proc.(type) {
 	case Primitive:
 		return proc(args, e)
+	case *Builtin:
+		return (*proc)(evalArgs(args, e), e)
 	case *Procedure:
 		return ApplyProcedure(proc, Evlis(args, e), e)
 	case *Macro:
-		return Eval(ApplyMacro(proc, args, e), e)
+		return MakeTailCall(ApplyMacro(proc, args, e), e)
 	default:
-		panic(fmt.Sprintf("unknown procedure type %T with contents %v args %v", proc, proc, args))
+		panic(MakeError(TypeErrorSym, "tried to apply a non-procedure", proc))
 	}
 }
 
-func ApplyProcedure(proc *Procedure, argsList Obj, e *Env) Obj {
-	args := listToSlice(argsList)
-	argsSyms := proc.args
-	if len(args) < len(argsSyms) {
-		panic(fmt.Sprintf("this procedure takes %v arguments, but was given %v", len(argsSyms), len(args)))
+// Call applies proc to already evaluated arguments with the interpreter's
+// engine and returns the result
+func Call(proc Obj, args []Obj, e *Env) Obj {
+	if e.interp.engine == VMEngine {
+		return e.interp.vmApply(proc, args)
 	}
+	return Eval(callForm(proc, args), e)
+}
 
-	bodyScope := MakeEnv(proc.scope)
+// (proc 'arg ...), which applies proc to args when evaluated
+func callForm(proc Obj, args []Obj) Obj {
+	quoted := make([]Obj, len(args))
+	for i, arg := range args {
+		quoted[i] = Cons(Primitive(QuotePrim), Cons(arg, Nil))
+	}
+	return Cons(proc, sliceToList(quoted))
+}
+
+// makes the scope for a procedure or macro body, binding argsSyms to args.
+// what is "procedure" or "macro", for errors.
+func bindArgs(what string, argsSyms []Symbol, variadic *Symbol, args []Obj, scope *Env) *Env {
+	checkArity(what, len(argsSyms), variadic != nil, len(args))
+
+	bodyScope := MakeEnv(scope)
 	for i, argSym := range argsSyms {
 		bodyScope.Bind(&argSym, args[i])
 	}
-
-	if proc.variadic != nil {
+	if variadic != nil {
 		rest := args[len(argsSyms):]
-		bodyScope.Bind(proc.variadic, sliceToList(rest))
-	} else {
-		if len(args) != len(argsSyms) {
-			panic(fmt.Sprintf("this procedure takes %v arguments, but was given %v", len(argsSyms), len(args)))
-		}
+		scope.interp.allocate(len(rest))
+		bodyScope.Bind(variadic, sliceToList(rest))
 	}
+	return bodyScope
+}
 
-	last := Obj(nil)
-	for _, expr := range listToSlice(proc.body) {
-		last = Eval(expr, bodyScope)
+func checkArity(what string, params int, variadic bool, args int) {
+	if args < params || !variadic && args != params {
+		panic(MakeError(ArityErrorSym, fmt.Sprintf("this %v takes %v arguments, but was given %v", what, params, args)))
 	}
-	return last
 }
 
-func ApplyMacro(proc *Macro, argsList Obj, e *Env) Obj {
-	args := listToSlice(argsList)
-	argsSyms := proc.args
-	if len(args) < len(argsSyms) {
-		panic(fmt.Sprintf("this macro takes %v arguments, but was given %v", len(argsSyms), len(args)))
+// like bindArgs, but for the compiled body of l, which finds its variables
+// in slots
+func bindSlots(what string, l *lambda, args []Obj, scope *Env) *Env {
+	checkArity(what, len(l.args), l.variadic != nil, len(args))
+
+	slots := make([]Obj, len(l.names))
+	copy(slots, args[:len(l.args)])
+	if l.variadic != nil {
+		scope.interp.allocate(len(args) - len(l.args))
+		slots[len(l.args)] = sliceToList(args[len(l.args):])
 	}
+	return makeSlotEnv(scope, l.names, slots)
+}
 
-	bodyScope := MakeEnv(proc.scope)
-	for i, argSym := range argsSyms {
-		bodyScope.Bind(&argSym, args[i])
+// evaluates all but the last body expression, which is returned as a tail call
+func ApplyProcedure(proc *Procedure, argsList Obj, e *Env) Obj {
+	bodyScope := bindArgs("procedure", proc.args, proc.variadic, listToSlice(argsList), proc.scope)
+
+	body := listToSlice(proc.body)
+	if len(body) == 0 {
+		return nil
+	}
+	for _, expr := range body[:len(body)-1] {
+		Eval(expr, bodyScope)
 	}
+	return MakeTailCall(body[len(body)-1], bodyScope)
+}
 
-	if proc.variadic != nil {
-		rest := args[len(argsSyms):]
-		bodyScope.Bind(proc.variadic, sliceToList(rest))
-	} else {
-		if len(args) != len(argsSyms) {
-			panic(fmt.Sprintf("this macro takes %v arguments, but was given %v", len(argsSyms), len(args)))
-		}
+func ApplyMacro(proc *Macro, argsList Obj, e *Env) Obj {
+	if proc.rules != nil {
+		return proc.expandRules(argsList)
 	}
+	bodyScope := bindArgs("macro", proc.args, proc.variadic, listToSlice(argsList), proc.scope)
 
 	last := Obj(nil)
 	for _, expr := range listToSlice(proc.body) {
@@ -0,0 +1,77 @@
+package lisp
+
+import (
+	"fmt"
+	"runtime/debug"
+	"testing"
+)
+
+// calls in tail position run in constant Go stack. the stack is kept small
+// enough that a loop growing it would overflow, which crashes the test.
+func TestTailCalls(t *testing.T) {
+	defer debug.SetMaxStack(debug.SetMaxStack(1 << 20))
+	tests := []struct {
+		name string
+		src  string
+	}{
+		{"if", `(define f (lambda (n) (if (= n 0) 'done (f (- n 1)))))`},
+		{"cond", `(define f (lambda (n) (cond ((= n 0) 'done) (else (f (- n 1))))))`},
+		{"body", `(define f (lambda (n) (define m (- n 1)) (if (< m 0) 'done (f m))))`},
+		{"macro", `
+(defmacro again (n) (list 'f (list '- n 1)))
+(define f (lambda (n) (if (= n 0) 'done (again n))))`},
+		{"apply", `(define f (lambda (n) (if (= n 0) 'done (apply f (list (- n 1))))))`},
+		{"mutual", `
+(define f (lambda (n) (if (= n 0) 'done (g (- n 1)))))
+(define g (lambda (n) (f n)))`},
+	}
+	for _, engine := range []Engine{TreeEngine, VMEngine} {
+		for _, test := range tests {
+			i := New(WithEngine(engine))
+			if _, err := i.EvalString(test.src); err != nil {
+				t.Fatal(err)
+			}
+			result, err := i.EvalString(`(f 200000)`)
+			if err != nil {
+				t.Errorf("%v: %v", test.name, err)
+				continue
+			}
+			if got := fmt.Sprint(result); got != "done" {
+				t.Errorf("%v: got %v", test.name, got)
+			}
+		}
+	}
+}
+
+// code using a macro sees it redefined, even once it's been compiled
+func TestRedefinedMacros(t *testing.T) {
+	tests := []struct {
+		name, src, want string
+	}{
+		{"macro", `
+(defmacro m (x) (list 'quote x))
+(define f (lambda () (m 1)))
+(define before (f))
+(defmacro m (x) (list 'list x x))
+(list before (f))`, "(1 (1 1))"},
+		{"procedure", `
+(defmacro m (x) (list 'quote x))
+(define f (lambda () (m 1)))
+(define before (f))
+(define m (lambda (x) (list 'called x)))
+(list before (f))`, "(1 (called 1))"},
+		{"special form", `
+(define f (lambda () (if 1 2)))
+(define before (f))
+(define if (lambda (a b) (list 'called a b)))
+(list before (f))`, "(2 (called 1 2))"},
+	}
+	for _, engine := range []Engine{TreeEngine, VMEngine} {
+		for _, test := range tests {
+			result, err := New(WithEngine(engine)).EvalString(test.src)
+			if got := fmt.Sprint(result); err != nil || got != test.want {
+				t.Errorf("%v: got %v, %v, want %v", test.name, got, err, test.want)
+			}
+		}
+	}
+}
@@ -0,0 +1,31 @@
+;; errors raised by primitives are error objects that can be caught with guard
+(print (guard (e ((error-object? e) (error-object-kind e)))
+         (car 5)))
+
+;; the error procedure raises an error object of kind error
+(define safe-div
+  (lambda (a b)
+    (if (= b 0)
+        (error 'safe-div a b)
+        (/ a b))))
+
+(print (guard (e ((error-object? e) (error-object-irritants e)))
+         (safe-div 10 0)))
+
+;; anything can be raised, and clauses that don't match re-raise
+(print (guard (e ((number? e) 'number))
+         (guard (e ((symbol? e) 'symbol))
+           (raise 42))))
+
+;; with-exception-handler can resume from raise-continuable
+(print (with-exception-handler
+        (lambda (c) 10)
+        (lambda () (+ 1 (raise-continuable 'need-a-number)))))
+
+;; other conditions call the handler where they're raised. returning from it
+;; raises another error, so it escapes with a continuation instead
+(print (call/cc
+        (lambda (k)
+          (with-exception-handler
+           (lambda (c) (k (list 'escaped c)))
+           (lambda () (+ 1 (raise 'oops)))))))
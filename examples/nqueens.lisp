@@ -47,7 +47,7 @@
   (cons x y))
 
 (defun not (x)
-  (if x () t))
+  (if x #f t))
 
 ;; (let1 var val body ...)
 ;; => ((lambda (var) body ...) val)
@@ -60,9 +60,9 @@
 ;; (and e1)
 ;; => e1
 (defmacro and (expr . rest)
-  (if rest
-      ;; `(if expr (and ,@rest) nil)
-      (list 'if expr (cons 'and rest))
+  (if (pair? rest)
+      ;; `(if expr (and ,@rest) #f)
+      (list 'if expr (cons 'and rest) #f)
     expr))
 
 ;; (or e1 e2 ...)
@@ -74,7 +74,7 @@
 ;; The reason to use the temporary variables is to avoid evaluating the
 ;; arguments more than once.
 (defmacro or (expr . rest)
-  (if rest
+  (if (pair? rest)
       (let1 var (gensym)
             (list 'let1 var expr
                    (list 'if var var (cons 'or rest))))
@@ -105,16 +105,16 @@
 ;;;
 
 ;; Applies each element of lis to pred. If pred returns a true value, terminate
-;; the evaluation and returns pred's return value. If all of them return (),
-;; returns ().
+;; the evaluation and returns pred's return value. If all of them return #f,
+;; returns #f.
 (defun any (lis pred)
-  (when lis
+  (and (pair? lis)
     (or (pred (car lis))
         (any (cdr lis) pred))))
 
 ;;; Applies each element of lis to fn, and returns their return values as a list.
 (defun map (lis fn)
-  (when lis
+  (when (pair? lis)
     (cons (fn (car lis))
           (map (cdr lis) fn))))
 
@@ -152,7 +152,7 @@
 
 ;; Applies fn to each element of lis.
 (defun for-each (lis fn)
-  (or (not lis)
+  (or (null? lis)
       (progn (fn (car lis))
              (for-each (cdr lis) fn))))
 
@@ -184,7 +184,7 @@
 
 ;; Print out the given board.
 (defun print (board)
-  (if (not board)
+  (if (null? board)
       '$
     (progn
       (println (car board))
@@ -6,7 +6,7 @@
         (* n (factorial (- n 1))))))
 
 ;; this is tail-recursive/iterative
-;; except this Lisp interpreter doesn't implement tail recursion ¯\_(ツ)_/¯
+;; tail calls don't grow the stack, so this runs in constant space
 (define factorial-iter
   (lambda (n)
     (define do-factorial-iter
@@ -29,3 +29,12 @@
 (factorial-iter 3)
 (factorial-iter 4)
 (factorial-iter 5)
+
+;; deep enough to overflow the stack if tail calls weren't eliminated
+(print 'big)
+(define count-down
+  (lambda (n)
+    (if (= n 0)
+        'done
+        (count-down (- n 1)))))
+(count-down 1000000)
@@ -1,61 +1,195 @@
-package main
-
-// Interned symbols are NOT garbage collected
-var symbols = map[string]*string{}
+package lisp
 
 var (
-	Nil                = Intern("nil")
-	True               = Intern("#t")
-	Dot                = Intern(".")
-	QuoteSym           = Intern("quote")
-	QuasiquoteSym      = Intern("quasiquote")
-	UnquoteSym         = Intern("unquote")
-	UnquoteSplicingSym = Intern("unquote-splicing")
-	Else               = Intern("else")
+	Nil                = &EmptyList{}
+	True               = &Boolean{value: true}
+	False              = &Boolean{value: false}
+	Dot                = intern(".")
+	QuoteSym           = intern("quote")
+	QuasiquoteSym      = intern("quasiquote")
+	UnquoteSym         = intern("unquote")
+	UnquoteSplicingSym = intern("unquote-splicing")
+	Else               = intern("else")
+	Ellipsis           = intern("...")
+	Underscore         = intern("_")
 )
 
 func BindGlobals(e *Env) {
-	prims := map[string]func(Obj, *Env) Obj{
-		"lambda":      LambdaPrim,
-		"cons":        ConsPrim,
-		"car":         CarPrim,
-		"cdr":         CdrPrim,
-		"define":      DefinePrim,
-		"defmacro":    DefMacroPrim,
-		"gensym":      GensymPrim,
-		"macroexpand": MacroExpandPrim,
-		"set!":        SetPrim,
-		"set-car!":    SetCarPrim,
-		"set-cdr!":    SetCdrPrim,
-		"if":          IfPrim,
-		"cond":        CondPrim,
-		"eq?":         EqPrim,
-		"symbol?":     IsSymbolPrim,
-		"pair?":       IsPairPrim,
-		"number?":     IsNumberPrim,
-		"procedure?":  IsProcedurePrim,
-		"macro?":      IsMacroPrim,
-		"primitive?":  IsPrimitivePrim,
-		"=":           EqPrim,
-		"<":           LessPrim,
-		"quote":       QuotePrim,
-		"quasiquote":  QuasiquotePrim,
-		"eval":        EvalPrim,
-		"apply":       ApplyPrim,
-		"+":           AddPrim,
-		"-":           SubPrim,
-		"*":           MulPrim,
-		"/":           DivPrim,
-		"modulo":      ModuloPrim,
-		"exit":        ExitPrim,
-		"print":       PrintPrim,
-		"__print-env": PrintEnvPrim,
+	forms := map[string]Primitive{
+		"lambda":          LambdaPrim,
+		"define":          DefinePrim,
+		"defmacro":        DefMacroPrim,
+		"syntax-rules":    SyntaxRulesPrim,
+		"define-syntax":   DefineSyntaxPrim,
+		"let-syntax":      LetSyntaxPrim,
+		"letrec-syntax":   LetrecSyntaxPrim,
+		"macroexpand":     MacroExpandPrim,
+		"macroexpand-1":   MacroExpand1Prim,
+		"macroexpand-all": MacroExpandAllPrim,
+		"set!":            SetPrim,
+		"if":              IfPrim,
+		"cond":            CondPrim,
+		"quote":           QuotePrim,
+		"quasiquote":      QuasiquotePrim,
+		"__print-env":     PrintEnvPrim,
+		"guard":           GuardPrim,
 	}
 
+	prims := map[string]Builtin{
+		"cons":       ConsPrim,
+		"car":        CarPrim,
+		"cdr":        CdrPrim,
+		"gensym":     GensymPrim,
+		"set-car!":   SetCarPrim,
+		"set-cdr!":   SetCdrPrim,
+		"eq?":        EqPrim,
+		"eqv?":       EqvPrim,
+		"equal?":     EqualPrim,
+		"symbol?":    IsSymbolPrim,
+		"pair?":      IsPairPrim,
+		"boolean?":   IsBooleanPrim,
+		"null?":      IsNullPrim,
+		"number?":    IsNumberPrim,
+		"procedure?": IsProcedurePrim,
+		"macro?":     IsMacroPrim,
+		"primitive?": IsPrimitivePrim,
+		"=":          NumEqPrim,
+		"<":          LessPrim,
+		">":          GreaterPrim,
+		"<=":         LessEqPrim,
+		">=":         GreaterEqPrim,
+		"eval":       EvalPrim,
+		"apply":      ApplyPrim,
+		"+":          AddPrim,
+		"-":          SubPrim,
+		"*":          MulPrim,
+		"/":          DivPrim,
+		"modulo":     ModuloPrim,
+		"exit":       ExitPrim,
+		"print":      PrintPrim,
+
+		"exact?":         IsExactPrim,
+		"inexact?":       IsInexactPrim,
+		"integer?":       IsIntegerPrim,
+		"exact->inexact": ExactToInexactPrim,
+		"inexact->exact": InexactToExactPrim,
+
+		"quotient":           QuotientPrim,
+		"remainder":          RemainderPrim,
+		"abs":                AbsPrim,
+		"min":                MinPrim,
+		"max":                MaxPrim,
+		"gcd":                GcdPrim,
+		"lcm":                LcmPrim,
+		"expt":               ExptPrim,
+		"exact-integer-sqrt": ExactIntegerSqrtPrim,
+		"sqrt":               SqrtPrim,
+		"floor":              FloorPrim,
+		"ceiling":            CeilingPrim,
+		"round":              RoundPrim,
+		"truncate":           TruncatePrim,
+		"zero?":              IsZeroPrim,
+		"positive?":          IsPositivePrim,
+		"negative?":          IsNegativePrim,
+		"even?":              IsEvenPrim,
+		"odd?":               IsOddPrim,
+
+		"error":                  ErrorPrim,
+		"raise":                  RaisePrim,
+		"raise-continuable":      RaiseContinuablePrim,
+		"with-exception-handler": WithExceptionHandlerPrim,
+		"error-object?":          IsErrorPrim,
+		"error-object-kind":      ErrorKindPrim,
+		"error-object-message":   ErrorMessagePrim,
+		"error-object-irritants": ErrorIrritantsPrim,
+		"error-object-backtrace": ErrorBacktracePrim,
+		"backtrace":              BacktracePrim,
+
+		"call-with-current-continuation": CallCCPrim,
+		"call/cc":                        CallCCPrim,
+		"dynamic-wind":                   DynamicWindPrim,
+		"call-with-prompt":               CallWithPromptPrim,
+		"abort-to-prompt":                AbortToPromptPrim,
+
+		"spawn":           SpawnPrim,
+		"join":            JoinPrim,
+		"thread?":         IsThreadPrim,
+		"make-channel":    MakeChannelPrim,
+		"channel?":        IsChannelPrim,
+		"channel-send":    ChannelSendPrim,
+		"channel-receive": ChannelReceivePrim,
+		"channel-select":  ChannelSelectPrim,
+		"make-mutex":      MakeMutexPrim,
+		"mutex?":          IsMutexPrim,
+		"mutex-lock!":     MutexLockPrim,
+		"mutex-unlock!":   MutexUnlockPrim,
+
+		"string?":        IsStringPrim,
+		"string-length":  StringLengthPrim,
+		"string-ref":     StringRefPrim,
+		"string":         StringPrim,
+		"string->list":   StringToListPrim,
+		"list->string":   ListToStringPrim,
+		"substring":      SubstringPrim,
+		"string-append":  StringAppendPrim,
+		"string->symbol": StringToSymbolPrim,
+		"symbol->string": SymbolToStringPrim,
+		"number->string": NumberToStringPrim,
+		"string->number": StringToNumberPrim,
+		"string=?":       StringEqualPrim,
+		"string<?":       StringLessPrim,
+		"string-index":   StringIndexPrim,
+		"string-split":   StringSplitPrim,
+		"string-join":    StringJoinPrim,
+		"display":        DisplayPrim,
+		"write":          WritePrim,
+		"newline":        NewlinePrim,
+
+		"vector?":         IsVectorPrim,
+		"make-vector":     MakeVectorPrim,
+		"vector":          VectorPrim,
+		"vector-length":   VectorLengthPrim,
+		"vector-ref":      VectorRefPrim,
+		"vector-set!":     VectorSetPrim,
+		"vector-fill!":    VectorFillPrim,
+		"vector->list":    VectorToListPrim,
+		"list->vector":    ListToVectorPrim,
+		"vector-map":      VectorMapPrim,
+		"vector-for-each": VectorForEachPrim,
+
+		"hash-table?":          IsHashTablePrim,
+		"make-hash-table":      MakeHashTablePrim,
+		"hash-table-ref":       HashTableRefPrim,
+		"hash-table-set!":      HashTableSetPrim,
+		"hash-table-delete!":   HashTableDeletePrim,
+		"hash-table-contains?": HashTableContainsPrim,
+		"hash-table-count":     HashTableCountPrim,
+		"hash-table-keys":      HashTableKeysPrim,
+		"hash-table->alist":    HashTableToAlistPrim,
+		"hash-table-update!":   HashTableUpdatePrim,
+
+		"char?":            IsCharPrim,
+		"char->integer":    CharToIntegerPrim,
+		"integer->char":    IntegerToCharPrim,
+		"char-upcase":      CharUpcasePrim,
+		"char-downcase":    CharDowncasePrim,
+		"char-alphabetic?": IsCharAlphabeticPrim,
+		"char-numeric?":    IsCharNumericPrim,
+		"char-whitespace?": IsCharWhitespacePrim,
+		"char=?":           CharEqualPrim,
+		"char<?":           CharLessPrim,
+		"char>?":           CharGreaterPrim,
+		"char<=?":          CharLessEqPrim,
+		"char>=?":          CharGreaterEqPrim,
+	}
+
+	for name, f := range forms {
+		e.Bind(e.interp.Intern(name), f)
+	}
 	for name, f := range prims {
-		e.Bind(Intern(name), Primitive(f))
+		e.Bind(e.interp.Intern(name), MakeBuiltin(f))
 	}
 
-	e.Bind(Intern("nil"), Nil)
-	e.Bind(Intern("#t"), True)
+	// for code written when nil was the empty list and false
+	e.Bind(e.interp.Intern("nil"), Nil)
 }
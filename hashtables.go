@@ -0,0 +1,350 @@
+package lisp
+
+import (
+	"fmt"
+	"hash"
+	"hash/fnv"
+	"reflect"
+)
+
+// what a hash table compares its keys with
+var (
+	eqTest    = intern("eq?")
+	eqvTest   = intern("eqv?")
+	equalTest = intern("equal?")
+)
+
+// the printed prefix of a hash table for each test, which the reader accepts
+// too
+var hashTablePrefixes = []struct {
+	prefix string
+	test   *Symbol
+}{
+	{"#hasheqv(", eqvTest}, // must be above #hasheq( which it starts with
+	{"#hasheq(", eqTest},
+	{"#hash(", equalTest},
+}
+
+type hashEntry struct {
+	key, value Obj
+	deleted    bool
+}
+
+// MakeHashTable makes an empty hash table comparing keys with test, which is
+// one of the symbols eq?, eqv? or equal?. It panics with a type-error for
+// anything else.
+func MakeHashTable(test *Symbol) *HashTable {
+	canonical := hashTest(test)
+	if canonical == nil {
+		panic(MakeError(TypeErrorSym, "hash tables compare keys with eq?, eqv? or equal?", test))
+	}
+	return &HashTable{test: canonical, buckets: map[interface{}][]*hashEntry{}}
+}
+
+// the test named by o, or nil if it isn't one. tables are compared with the
+// symbols above by pointer, so they only ever hold those.
+func hashTest(o Obj) *Symbol {
+	for _, p := range hashTablePrefixes {
+		if p.test.Equal(o) {
+			return p.test
+		}
+	}
+	return nil
+}
+
+func (t *HashTable) same(a, b Obj) bool {
+	if t.test == equalTest {
+		return equal(a, b)
+	}
+	return eqv(a, b)
+}
+
+func (t *HashTable) lookup(key Obj) *hashEntry {
+	for _, entry := range t.buckets[t.hashKey(key)] {
+		if t.same(entry.key, key) {
+			return entry
+		}
+	}
+	return nil
+}
+
+func (t *HashTable) set(key, value Obj) {
+	if entry := t.lookup(key); entry != nil {
+		entry.value = value
+		return
+	}
+	entry := &hashEntry{key: key, value: value}
+	k := t.hashKey(key)
+	t.buckets[k] = append(t.buckets[k], entry)
+	t.entries = append(t.entries, entry)
+	t.count++
+}
+
+func (t *HashTable) delete(key Obj) {
+	k := t.hashKey(key)
+	bucket := t.buckets[k]
+	for i, entry := range bucket {
+		if !t.same(entry.key, key) {
+			continue
+		}
+		entry.deleted = true
+		t.count--
+		if len(bucket) == 1 {
+			delete(t.buckets, k)
+		} else {
+			t.buckets[k] = append(bucket[:i:i], bucket[i+1:]...)
+		}
+		break
+	}
+	// drop deleted entries once they're most of the list
+	if len(t.entries) > 2*t.count+8 {
+		t.entries = t.live()
+	}
+}
+
+// the entries that haven't been deleted, in the order they were added
+func (t *HashTable) live() []*hashEntry {
+	live := make([]*hashEntry, 0, t.count)
+	for _, entry := range t.entries {
+		if !entry.deleted {
+			live = append(live, entry)
+		}
+	}
+	return live
+}
+
+// keys that are the same must have the same hash key, but keys with the same
+// hash key can still differ, so they're compared with same
+type (
+	smallKey   int64
+	exactKey   string
+	inexactKey float64
+	nanKey     struct{}
+	charKey    rune
+	funcKey    uintptr
+	equalKey   uint64
+)
+
+func (t *HashTable) hashKey(o Obj) interface{} {
+	switch o := o.(type) {
+	case *Symbol:
+		return *o
+	case *Number:
+		return numKey(o)
+	case *Char:
+		return charKey(o.r)
+	case Primitive:
+		return funcKey(reflect.ValueOf(o).Pointer())
+	case *Pair, *Vector, *String:
+		if t.test == equalTest {
+			h := fnv.New64a()
+			budget := 32
+			hashContents(h, o, &budget)
+			return equalKey(h.Sum64())
+		}
+	}
+	return o
+}
+
+// numbers with the same value and exactness, like eqv compares them
+func numKey(n *Number) interface{} {
+	if n.kind == kindReal {
+		if n.isNaN() {
+			return nanKey{}
+		}
+		// 0.0 and -0.0 are the same key, as they're the same number
+		return inexactKey(n.float)
+	}
+	if small, ok := n.Int64(); ok {
+		return smallKey(small)
+	}
+	return exactKey(n.String())
+}
+
+// writes what equal compares to h, looking at no more than budget objects so
+// that big and cyclic structures don't take forever
+func hashContents(h hash.Hash64, o Obj, budget *int) {
+	if *budget == 0 {
+		return
+	}
+	*budget--
+	switch o := o.(type) {
+	case *Pair:
+		h.Write([]byte{'('})
+		hashContents(h, o.Car, budget)
+		hashContents(h, o.Cdr, budget)
+	case *Vector:
+		h.Write([]byte{'#'})
+		for _, item := range o.items {
+			hashContents(h, item, budget)
+		}
+	case *String:
+		h.Write([]byte{'"'})
+		h.Write([]byte(o.s))
+	case *Symbol:
+		h.Write([]byte{'\''})
+		h.Write([]byte(*o.s))
+	case *Char:
+		h.Write([]byte{'\\'})
+		h.Write([]byte(string(o.r)))
+	case *Number:
+		if o.kind == kindReal && o.float == 0 {
+			h.Write([]byte{'0'})
+		} else if o.kind == kindReal && o.isNaN() {
+			h.Write([]byte{'n'})
+		} else {
+			h.Write([]byte(o.String()))
+		}
+	}
+	// everything else is compared by reference, so it only adds to the hash
+	// of what it's in
+}
+
+func (t *HashTable) String() string {
+	return toString(t)
+}
+
+// the entries as (key . value) pairs, with the prefix for the table's test
+func (p *printer) hashTable(t *HashTable) {
+	if !p.enter(t) {
+		return
+	}
+	for _, prefix := range hashTablePrefixes {
+		if prefix.test == t.test {
+			p.b.WriteString(prefix.prefix)
+		}
+	}
+	for i, entry := range t.live() {
+		if i > 0 {
+			p.b.WriteByte(' ')
+		}
+		p.print(Cons(entry.key, entry.value))
+	}
+	p.b.WriteByte(')')
+	p.leave(t)
+}
+
+var _ fmt.Stringer = &HashTable{}
+
+// checks that o is a hash table, for primitives that only take hash tables
+func hashTableArg(name string, o Obj) *HashTable {
+	t, ok := o.(*HashTable)
+	if !ok {
+		panic(MakeError(TypeErrorSym, fmt.Sprintf("%v takes a hash table", name), o))
+	}
+	return t
+}
+
+func IsHashTablePrim(args []Obj, e *Env) Obj {
+	if len(args) != 1 {
+		panic(MakeError(ArityErrorSym, "hash-table? takes 1 argument"))
+	}
+	_, ok := args[0].(*HashTable)
+	return boolToLisp(ok)
+}
+
+// (make-hash-table [test]), where test is 'eq?, 'eqv? or 'equal?, the default
+func MakeHashTablePrim(args []Obj, e *Env) Obj {
+	if len(args) > 1 {
+		panic(MakeError(ArityErrorSym, "make-hash-table takes 0 or 1 arguments"))
+	}
+	if len(args) == 0 {
+		return MakeHashTable(equalTest)
+	}
+	test := hashTest(args[0])
+	if test == nil {
+		panic(MakeError(TypeErrorSym, "make-hash-table takes 'eq?, 'eqv? or 'equal?", args[0]))
+	}
+	return MakeHashTable(test)
+}
+
+// (hash-table-ref table key [failure]), which calls failure with no arguments
+// if key isn't in table
+func HashTableRefPrim(args []Obj, e *Env) Obj {
+	if len(args) != 2 && len(args) != 3 {
+		panic(MakeError(ArityErrorSym, "hash-table-ref takes 2 or 3 arguments"))
+	}
+	t := hashTableArg("hash-table-ref", args[0])
+	if entry := t.lookup(args[1]); entry != nil {
+		return entry.value
+	}
+	if len(args) == 3 {
+		return Call(args[2], nil, e)
+	}
+	panic(MakeError(RangeErrorSym, "hash-table-ref key not found", args[1]))
+}
+
+func HashTableSetPrim(args []Obj, e *Env) Obj {
+	if len(args) != 3 {
+		panic(MakeError(ArityErrorSym, "hash-table-set! takes 3 arguments"))
+	}
+	hashTableArg("hash-table-set!", args[0]).set(args[1], args[2])
+	return args[2]
+}
+
+func HashTableDeletePrim(args []Obj, e *Env) Obj {
+	if len(args) != 2 {
+		panic(MakeError(ArityErrorSym, "hash-table-delete! takes 2 arguments"))
+	}
+	hashTableArg("hash-table-delete!", args[0]).delete(args[1])
+	return Nil
+}
+
+func HashTableContainsPrim(args []Obj, e *Env) Obj {
+	if len(args) != 2 {
+		panic(MakeError(ArityErrorSym, "hash-table-contains? takes 2 arguments"))
+	}
+	return boolToLisp(hashTableArg("hash-table-contains?", args[0]).lookup(args[1]) != nil)
+}
+
+func HashTableCountPrim(args []Obj, e *Env) Obj {
+	if len(args) != 1 {
+		panic(MakeError(ArityErrorSym, "hash-table-count takes 1 argument"))
+	}
+	return MakeInt(int64(hashTableArg("hash-table-count", args[0]).count))
+}
+
+func HashTableKeysPrim(args []Obj, e *Env) Obj {
+	if len(args) != 1 {
+		panic(MakeError(ArityErrorSym, "hash-table-keys takes 1 argument"))
+	}
+	keys := []Obj{}
+	for _, entry := range hashTableArg("hash-table-keys", args[0]).live() {
+		keys = append(keys, entry.key)
+	}
+	e.interp.allocate(len(keys))
+	return sliceToList(keys)
+}
+
+func HashTableToAlistPrim(args []Obj, e *Env) Obj {
+	if len(args) != 1 {
+		panic(MakeError(ArityErrorSym, "hash-table->alist takes 1 argument"))
+	}
+	pairs := []Obj{}
+	for _, entry := range hashTableArg("hash-table->alist", args[0]).live() {
+		pairs = append(pairs, Cons(entry.key, entry.value))
+	}
+	e.interp.allocate(2 * len(pairs))
+	return sliceToList(pairs)
+}
+
+// (hash-table-update! table key proc [failure]) sets key to proc applied to
+// its value, or to what failure returns if key isn't in table
+func HashTableUpdatePrim(args []Obj, e *Env) Obj {
+	if len(args) != 3 && len(args) != 4 {
+		panic(MakeError(ArityErrorSym, "hash-table-update! takes 3 or 4 arguments"))
+	}
+	t := hashTableArg("hash-table-update!", args[0])
+	var value Obj
+	if entry := t.lookup(args[1]); entry != nil {
+		value = entry.value
+	} else if len(args) == 4 {
+		value = Call(args[3], nil, e)
+	} else {
+		panic(MakeError(RangeErrorSym, "hash-table-update! key not found", args[1]))
+	}
+	value = Call(args[2], []Obj{value}, e)
+	// proc may have changed the table, so look the key up again
+	t.set(args[1], value)
+	return value
+}
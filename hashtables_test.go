@@ -0,0 +1,32 @@
+package lisp
+
+import (
+	"math"
+	"math/big"
+	"testing"
+)
+
+// keys that a table considers the same have to land in the same bucket
+func TestHashKeysMatchEquality(t *testing.T) {
+	shared := MakeString("shared")
+	values := []Obj{
+		MakeInt(1), MakeInt(1), MakeFloat(1), MakeFloat(0), MakeFloat(math.Copysign(0, -1)),
+		MakeFloat(math.NaN()), MakeFloat(math.NaN()), MakeRat(big.NewRat(1, 3)), MakeRat(big.NewRat(2, 6)),
+		MakeNum(new(big.Int).Lsh(big.NewInt(1), 100)), MakeNum(new(big.Int).Lsh(big.NewInt(1), 100)),
+		intern("a"), intern("a"), shared, shared, MakeString("shared"),
+		List(MakeInt(1), MakeString("x")), List(MakeInt(1), MakeString("x")),
+		MakeVector([]Obj{MakeFloat(0)}), MakeVector([]Obj{MakeFloat(math.Copysign(0, -1))}),
+		MakeChar('a'), MakeChar('a'), List(MakeChar('b')), List(MakeChar('b')),
+		Primitive(QuotePrim), Primitive(QuotePrim), MakeBuiltin(CarPrim), MakeBuiltin(CarPrim), Nil, True, False,
+	}
+	for _, test := range []*Symbol{eqTest, eqvTest, equalTest} {
+		table := MakeHashTable(test)
+		for _, a := range values {
+			for _, b := range values {
+				if table.same(a, b) && table.hashKey(a) != table.hashKey(b) {
+					t.Errorf("%v: %v and %v are the same but hash differently", test, a, b)
+				}
+			}
+		}
+	}
+}
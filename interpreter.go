@@ -0,0 +1,357 @@
+// Package lisp is a small Lisp interpreter that can be embedded in Go
+// programs.
+package lisp
+
+import (
+	"context"
+	_ "embed"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Interpreter owns a symbol table and a global environment, so several can
+// coexist in one process without sharing anything. An Interpreter is safe
+// for concurrent use: it runs Lisp code on one goroutine at a time, see
+// threads.go. Go functions registered with RegisterFunc run without holding
+// it, so they can call back into the interpreter.
+type Interpreter struct {
+	symbols       *SymbolTable
+	global        *Env
+	gensymCounter uint64
+	engine        Engine
+	out           io.Writer // where print and display write to
+	nilIsFalse    bool      // whether () is false as well as #f
+
+	// where the reader found recently read lists, so that errors can point
+	// at the code that caused them
+	positions *positionTable
+
+	// what each macro use evaluated by the tree engine expanded to, and
+	// the pairs and vectors those uses were made of, see expandOnce
+	expansions map[*Pair]cachedExpansion
+	expanded   map[Obj]bool
+
+	// how many times a variable has been bound to or from a macro or
+	// special form, so the VM can tell when code it compiled might be out
+	// of date, see lambdaCode
+	syntaxChanges uint
+
+	// the applications being evaluated, innermost last. it isn't unwound
+	// by panics, so after recovering it still shows where the panic came
+	// from, see Eval
+	stack []frame
+
+	// the handlers installed by with-exception-handler, innermost last.
+	// a nil handler is installed by guard and means "unwind to me".
+	handlers []Obj
+	// the condition the handlers were last done with, which is left to
+	// unwind, see deliver
+	delivered interface{}
+
+	// the innermost call-with-prompt running, see prompt
+	prompt *prompt
+
+	// held while running Lisp code, see threads.go
+	lock    sync.Mutex
+	thread  *Thread // the thread running, nil if it was called from Go
+	threads int     // how many threads are running
+	ticks   uint
+
+	limits Limits
+	budget *budget // what the running call from Go has used of limits
+
+	// the bodies of prompts whose continuations were garbage collected
+	// without being resumed, see collectAbandoned
+	abandonedMu sync.Mutex
+	abandoned   []*body
+}
+
+// Func is a Go function that can be called from Lisp with RegisterFunc.
+// Its arguments are already evaluated, and a returned error is raised as a
+// Lisp condition.
+type Func func(args []Obj) (Obj, error)
+
+// FuncContext is a Func that's told when to stop. ctx is done when the call
+// into the interpreter it's running for is cancelled or runs out of time,
+// and evaluation stops with the usual error once it returns.
+type FuncContext func(ctx context.Context, args []Obj) (Obj, error)
+
+// Engine is how an Interpreter evaluates code
+type Engine int
+
+const (
+	// VMEngine compiles each form to bytecode and runs it on a stack machine.
+	// It expands macros as it compiles, so backtraces taken while one is
+	// being expanded don't show the calls that were running.
+	VMEngine Engine = iota
+	// TreeEngine evaluates forms directly with Eval. It's slower, and kept as
+	// the reference for how the VM should behave.
+	TreeEngine
+)
+
+// Option configures an Interpreter made with New
+type Option func(*Interpreter)
+
+// WithEngine picks the engine, VMEngine by default
+func WithEngine(engine Engine) Option {
+	return func(i *Interpreter) {
+		i.engine = engine
+	}
+}
+
+// WithOutput sends what print and display write to w instead of stdout
+func WithOutput(w io.Writer) Option {
+	return func(i *Interpreter) {
+		i.out = w
+	}
+}
+
+// WithNilAsFalse makes the empty list false in conditionals as well as #f,
+// like it was when nil was both, for code that still relies on it
+func WithNilAsFalse() Option {
+	return func(i *Interpreter) {
+		i.nilIsFalse = true
+	}
+}
+
+// whether o counts as false in conditionals
+func (i *Interpreter) isFalse(o Obj) bool {
+	return o == Obj(False) || i.nilIsFalse && o == Obj(Nil)
+}
+
+//go:embed prelude.lisp
+var prelude string
+
+// New makes an interpreter with the primitives and the prelude loaded
+func New(opts ...Option) *Interpreter {
+	i := &Interpreter{
+		symbols:    MakeSymbolTable(),
+		positions:  makePositionTable(),
+		expansions: map[*Pair]cachedExpansion{},
+		expanded:   map[Obj]bool{},
+		out:        os.Stdout,
+	}
+	for _, opt := range opts {
+		opt(i)
+	}
+	i.global = MakeEnv(nil)
+	i.global.interp = i
+	BindGlobals(i.global)
+
+	rd := i.NewReader(strings.NewReader(prelude), "prelude.lisp")
+	if _, err := i.evalReader(rd, newBudget(context.Background(), Limits{})); err != nil {
+		panic("bug: error loading prelude: " + err.Error())
+	}
+	return i
+}
+
+// Intern returns the symbol with the given name in this interpreter
+func (i *Interpreter) Intern(name string) *Symbol {
+	return i.symbols.Intern(name)
+}
+
+// generates an un-interned symbol
+// for use inside of macros only please and thank you
+//
+// names can collide since it's not interned
+// the name is for debugging purposes only
+func (i *Interpreter) Gensym() *Symbol {
+	n := atomic.AddUint64(&i.gensymCounter, 1) - 1
+	s := "__GEN-" + strconv.FormatUint(n, 36)
+	return &Symbol{s: &s}
+}
+
+// NewReader makes a Reader that interns symbols in this interpreter.
+// file is used for error messages and can be empty.
+func (i *Interpreter) NewReader(r io.Reader, file string) *Reader {
+	return makeReader(r, file, i.symbols, i.positions)
+}
+
+// where o was read, if it was
+func (i *Interpreter) posOf(o Obj) Pos {
+	if pair, ok := o.(*Pair); ok {
+		return i.positions.get(pair)
+	}
+	return Pos{}
+}
+
+// Eval evaluates o in the global environment
+func (i *Interpreter) Eval(o Obj) (Obj, error) {
+	return i.EvalContext(context.Background(), o)
+}
+
+// EvalContext is Eval, stopping with a cancelled error if ctx is done first.
+// The interpreter can still be used afterwards, though anything the code was
+// in the middle of, like a mutex it held, is left as it was.
+func (i *Interpreter) EvalContext(ctx context.Context, o Obj) (Obj, error) {
+	b := newBudget(ctx, i.limits)
+	defer b.release()
+	return i.evalWith(b, o)
+}
+
+// evaluates o in the global environment, on budget b
+func (i *Interpreter) evalWith(b *budget, o Obj) (result Obj, err error) {
+	i.enter(b)
+	defer i.lock.Unlock()
+	defer i.recoverError(&err)
+	// stops between forms even if they make no calls
+	i.checkStopped()
+	return i.eval(o, i.global), nil
+}
+
+// evaluates o in e with the interpreter's engine
+func (i *Interpreter) eval(o Obj, e *Env) Obj {
+	if i.engine == TreeEngine {
+		return Eval(o, e)
+	}
+	// the calls in o have their own frames
+	return i.run(i.compile(o, e, false), e, len(i.stack))
+}
+
+// EvalReader evaluates everything in r, stopping at the first error, and
+// returns the value of the last expression
+func (i *Interpreter) EvalReader(r io.Reader) (Obj, error) {
+	return i.EvalReaderContext(context.Background(), r)
+}
+
+// EvalReaderContext is EvalReader, stopping if ctx is done, see EvalContext
+func (i *Interpreter) EvalReaderContext(ctx context.Context, r io.Reader) (Obj, error) {
+	return i.evalReader(i.NewReader(r, ""), newBudget(ctx, i.limits))
+}
+
+// EvalString is EvalReader for a string
+func (i *Interpreter) EvalString(src string) (Obj, error) {
+	return i.EvalStringContext(context.Background(), src)
+}
+
+// EvalStringContext is EvalString, stopping if ctx is done, see EvalContext
+func (i *Interpreter) EvalStringContext(ctx context.Context, src string) (Obj, error) {
+	return i.EvalReaderContext(ctx, strings.NewReader(src))
+}
+
+// EvalFile is EvalReader for a file, ignoring a #! line at the start
+func (i *Interpreter) EvalFile(path string) (Obj, error) {
+	return i.EvalFileContext(context.Background(), path)
+}
+
+// EvalFileContext is EvalFile, stopping if ctx is done, see EvalContext
+func (i *Interpreter) EvalFileContext(ctx context.Context, path string) (Obj, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	rd := i.NewReader(f, path)
+	rd.skipShebang()
+	return i.evalReader(rd, newBudget(ctx, i.limits))
+}
+
+// evaluates everything in rd on budget b, which it releases
+func (i *Interpreter) evalReader(rd *Reader, b *budget) (Obj, error) {
+	defer b.release()
+	result := Obj(Nil)
+	for {
+		o, err := rd.Next()
+		if err == io.EOF {
+			return result, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		result, err = i.evalWith(b, o)
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// Define binds name to value in the global environment
+func (i *Interpreter) Define(name string, value Obj) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	i.global.Bind(i.Intern(name), value)
+}
+
+// RegisterFunc defines name as a procedure implemented by fn
+func (i *Interpreter) RegisterFunc(name string, fn Func) {
+	i.RegisterFuncContext(name, func(_ context.Context, args []Obj) (Obj, error) {
+		return fn(args)
+	})
+}
+
+// RegisterFuncContext is like RegisterFunc, for functions that may take a
+// while and should stop when evaluation is cancelled
+func (i *Interpreter) RegisterFuncContext(name string, fn FuncContext) {
+	i.Define(name, MakeBuiltin(func(args []Obj, e *Env) Obj {
+		// fn may hold on to its arguments, and call back into the
+		// interpreter
+		var result Obj
+		var err error
+		ctx := e.interp.budget.timed
+		e.interp.blocking(func(<-chan struct{}) { result, err = fn(ctx, append([]Obj(nil), args...)) })
+		switch err := err.(type) {
+		case nil:
+		case *Error:
+			raise(err)
+		case *Raised:
+			raise(err.Value)
+		case *ExitError:
+			panic(err)
+		default:
+			raise(MakeError(ErrorSym, err.Error()))
+		}
+		if result == nil {
+			return Nil
+		}
+		return result
+	}))
+}
+
+// Call calls the procedure bound to name in the global environment
+func (i *Interpreter) Call(name string, args ...Obj) (Obj, error) {
+	return i.CallContext(context.Background(), name, args...)
+}
+
+// CallContext is Call, stopping if ctx is done, see EvalContext
+func (i *Interpreter) CallContext(ctx context.Context, name string, args ...Obj) (result Obj, err error) {
+	b := newBudget(ctx, i.limits)
+	defer b.release()
+	i.enter(b)
+	defer i.lock.Unlock()
+	defer i.recoverError(&err)
+	proc := i.global.Resolve(i.Intern(name))
+	return Call(proc, args, i.global), nil
+}
+
+// turns a panic from evaluation into an error. this must be deferred
+// directly so that it can recover.
+func (i *Interpreter) recoverError(err *error) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	defer func() {
+		i.stack = nil
+		i.handlers = nil
+		i.prompt = nil
+	}()
+	if exit, ok := r.(*ExitError); ok {
+		*err = exit
+		return
+	}
+	if condition, ok := i.conditionOf(r); ok {
+		if raised, ok := r.(*Raised); ok {
+			// keeps the backtrace
+			*err = raised
+			return
+		}
+		*err = asError(condition)
+		return
+	}
+	*err = fmt.Errorf("bug: %v", r)
+}
@@ -0,0 +1,120 @@
+package lisp_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"lisp"
+)
+
+func TestGoAPI(t *testing.T) {
+	interp := lisp.New()
+	interp.Define("greeting", lisp.MakeString("hello"))
+	interp.RegisterFunc("greet", func(args []lisp.Obj) (lisp.Obj, error) {
+		if len(args) != 1 {
+			return nil, lisp.MakeError(lisp.ArityErrorSym, "greet takes 1 argument")
+		}
+		return lisp.List(interp.Intern("greeted"), args[0]), nil
+	})
+	interp.RegisterFunc("fail", func(args []lisp.Obj) (lisp.Obj, error) {
+		return nil, errors.New("it failed")
+	})
+
+	if _, err := interp.EvalReader(strings.NewReader(`(define shout (lambda (s) (list (greet s) greeting)))`)); err != nil {
+		t.Fatal(err)
+	}
+	result, err := interp.Call("shout", lisp.MakeString("world"))
+	if got, want := fmt.Sprint(result), `((greeted "world") "hello")`; err != nil || got != want {
+		t.Errorf("got %v, %v, want %v", got, err, want)
+	}
+
+	// errors from Go functions are raised as conditions
+	result, err = interp.EvalString(`
+(list (guard (e ((error-object? e) (error-object-message e))) (fail))
+      (guard (e ((error-object? e) (error-object-kind e))) (greet)))`)
+	if got, want := fmt.Sprint(result), `("it failed" arity-error)`; err != nil || got != want {
+		t.Errorf("got %v, %v, want %v", got, err, want)
+	}
+
+	if _, err := interp.Call("undefined-procedure"); err == nil {
+		t.Error("calling an undefined procedure should fail")
+	}
+}
+
+// interpreters don't share definitions or symbols
+func TestSeparateInterpreters(t *testing.T) {
+	a, b := lisp.New(), lisp.New()
+	if _, err := a.EvalString(`(define only-in-a 1)`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.EvalString(`only-in-a`); err == nil {
+		t.Error("a's definition is visible in b")
+	}
+	if a.Intern("only-in-a").Equal(b.Intern("only-in-a")) {
+		t.Error("a and b share a symbol table")
+	}
+	if !a.Intern("x").Equal(a.Intern("x")) {
+		t.Error("symbols aren't interned")
+	}
+}
+
+// funcs registered from the same Go code, and continuations, are still
+// different objects
+func TestBuiltinIdentity(t *testing.T) {
+	interp := lisp.New()
+	for _, name := range []string{"one", "other"} {
+		interp.RegisterFunc(name, func(args []lisp.Obj) (lisp.Obj, error) { return nil, nil })
+	}
+	result, err := interp.EvalString(`
+(define k1 (call/cc (lambda (k) k)))
+(define k2 (call/cc (lambda (k) k)))
+(define t (make-hash-table 'eq?))
+(hash-table-set! t one 1)
+(hash-table-set! t other 2)
+(hash-table-set! t k1 3)
+(hash-table-set! t k2 4)
+(list (eq? one other) (eqv? one other) (eq? one one) (eq? k1 k2) (eq? car car) (hash-table-count t))`)
+	if got, want := fmt.Sprint(result), `(#f #f #t #f #t 4)`; err != nil || got != want {
+		t.Errorf("got %v, %v, want %v", got, err, want)
+	}
+}
+
+// tables made from Go take the test as any interpreter's symbol
+func TestMakeHashTableFromGo(t *testing.T) {
+	interp := lisp.New()
+	interp.Define("t", lisp.MakeHashTable(interp.Intern("equal?")))
+	result, err := interp.EvalString(`(hash-table-set! t (list 1 2) 'v) (list (hash-table-ref t (list 1 2)) t)`)
+	if got, want := fmt.Sprint(result), `(v #hash(((1 2) . v)))`; err != nil || got != want {
+		t.Errorf("got %v, %v, want %v", got, err, want)
+	}
+}
+
+// a Go function registered with a context stops when evaluation is
+// cancelled or runs out of time
+func TestRegisterFuncContext(t *testing.T) {
+	interp := lisp.New(lisp.WithLimits(lisp.Limits{Timeout: time.Minute}))
+	interp.RegisterFuncContext("wait", func(ctx context.Context, args []lisp.Obj) (lisp.Obj, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	if _, err := interp.EvalStringContext(ctx, `(wait)`); !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v, want it cancelled", err)
+	}
+
+	timed := lisp.New(lisp.WithLimits(lisp.Limits{Timeout: 20 * time.Millisecond}))
+	timed.RegisterFuncContext("wait", func(ctx context.Context, args []lisp.Obj) (lisp.Obj, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	var lispErr *lisp.Error
+	if _, err := timed.EvalString(`(wait)`); !errors.As(err, &lispErr) || lispErr.Kind != lisp.LimitErrorSym {
+		t.Errorf("got %v, want a limit-error", err)
+	}
+}
@@ -0,0 +1,119 @@
+package lisp
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// LimitErrorSym is the kind of error raised when evaluation goes over
+	// one of its Limits
+	LimitErrorSym = intern("limit-error")
+	// CancelledErrorSym is the kind of error raised when the context passed
+	// to EvalContext or the like is done. The error unwraps to the
+	// context's error.
+	CancelledErrorSym = intern("cancelled")
+)
+
+// Limits bounds the work that each call into the interpreter from Go, like
+// Eval or EvalString, can do along with the threads it spawns. Going over
+// one raises a limit-error, which is raised again each time it's caught and
+// evaluation carries on, so code can clean up but can't keep going. Zero
+// means no limit.
+type Limits struct {
+	// how many steps evaluation can take, which is about one for each
+	// procedure call
+	Steps int
+	// how deeply calls can nest, not counting tail calls. this keeps deep
+	// recursion from running out of Go stack with TreeEngine.
+	Depth int
+	// how many cons cells Lisp code can make, with cons and list and
+	// quasiquote, and with procedures like vector->list that make lists
+	// from other data. each slot of a vector from make-vector counts as
+	// one too, so one call can't use up the memory.
+	Conses int
+	// how long it can run for. this is checked between steps, so a
+	// primitive that does a lot of work in one go, like
+	// (expt 10 10000000), finishes before it's stopped.
+	Timeout time.Duration
+}
+
+// WithLimits bounds each call into the interpreter, see Limits
+func WithLimits(limits Limits) Option {
+	return func(i *Interpreter) {
+		i.limits = limits
+	}
+}
+
+// budget is what a call into the interpreter from Go has used of its Limits
+type budget struct {
+	limits        Limits
+	steps, conses int
+	ctx           context.Context // the caller's
+	timed         context.Context // ctx with the time limit, for FuncContexts
+	done          <-chan struct{} // closed when timed is done
+	cancel        context.CancelFunc
+	users         int32 // the call and the threads using it, see release
+}
+
+func newBudget(ctx context.Context, limits Limits) *budget {
+	b := &budget{limits: limits, ctx: ctx, timed: ctx, cancel: func() {}, users: 1}
+	if limits.Timeout > 0 {
+		b.timed, b.cancel = context.WithTimeout(ctx, limits.Timeout)
+	}
+	b.done = b.timed.Done()
+	return b
+}
+
+// a thread spawned by the call is using b too
+func (b *budget) acquire() {
+	atomic.AddInt32(&b.users, 1)
+}
+
+// stops b's timer once the call and its threads are finished with it
+func (b *budget) release() {
+	if atomic.AddInt32(&b.users, -1) == 0 {
+		b.cancel()
+	}
+}
+
+// counts a step, raising a limit-error if the budget has run out
+func (i *Interpreter) step() {
+	b := i.budget
+	b.steps++
+	if b.limits.Steps > 0 && b.steps > b.limits.Steps {
+		panic(MakeError(LimitErrorSym, "step limit exceeded", MakeInt(int64(b.limits.Steps))))
+	}
+	if b.limits.Depth > 0 && len(i.stack) > b.limits.Depth {
+		panic(MakeError(LimitErrorSym, "depth limit exceeded", MakeInt(int64(b.limits.Depth))))
+	}
+	i.checkStopped()
+}
+
+// raises a cancelled error if the caller's context is done, or a
+// limit-error if the time's up
+func (i *Interpreter) checkStopped() {
+	b := i.budget
+	select {
+	case <-b.done:
+		if err := b.ctx.Err(); err != nil {
+			cancelled := MakeError(CancelledErrorSym, "evaluation cancelled", MakeString(err.Error()))
+			cancelled.cause = err
+			panic(cancelled)
+		}
+		panic(MakeError(LimitErrorSym, "time limit exceeded", MakeString(b.limits.Timeout.String())))
+	default:
+	}
+}
+
+// counts cons cells made by Lisp code, before they're made
+func (i *Interpreter) allocate(conses int) {
+	b := i.budget
+	if b.limits.Conses > 0 && conses > b.limits.Conses-b.conses {
+		// stays over, so it's raised again if caught
+		b.conses = b.limits.Conses + 1
+		panic(MakeError(LimitErrorSym, "cons limit exceeded", MakeInt(int64(b.limits.Conses))))
+	}
+	b.conses += conses
+}
@@ -0,0 +1,161 @@
+package lisp
+
+// (macroexpand-1 form) expands form once if it's a use of a macro, and
+// returns it as it is otherwise. form isn't evaluated.
+func MacroExpand1Prim(o Obj, e *Env) Obj {
+	form, _ := macroexpand1(macroexpandArg("macroexpand-1", o), e)
+	return form
+}
+
+// (macroexpand form) expands form until it's no longer a use of a macro
+func MacroExpandPrim(o Obj, e *Env) Obj {
+	return macroexpand(macroexpandArg("macroexpand", o), e)
+}
+
+// (macroexpand-all form) expands every macro in form, not just the one at
+// its head
+func MacroExpandAllPrim(o Obj, e *Env) Obj {
+	return macroexpandAll(macroexpandArg("macroexpand-all", o), MakeEnv(e))
+}
+
+func macroexpandArg(name string, o Obj) Obj {
+	args := listToSlice(o)
+	if len(args) != 1 {
+		panic(MakeError(SyntaxErrorSym, name+" takes 1 argument"))
+	}
+	return args[0]
+}
+
+// expands form once, returning whether it was a use of a macro
+func macroexpand1(form Obj, e *Env) (Obj, bool) {
+	pair, ok := form.(*Pair)
+	if !ok {
+		return form, false
+	}
+	head, ok := pair.Car.(*Symbol)
+	if !ok {
+		return form, false
+	}
+	value, _ := e.lookup(head)
+	macro, ok := value.(*Macro)
+	if !ok {
+		return form, false
+	}
+	return ApplyMacro(macro, pair.Cdr, e), true
+}
+
+func macroexpand(form Obj, e *Env) Obj {
+	for expanded := true; expanded; {
+		form, expanded = macroexpand1(form, e)
+	}
+	return form
+}
+
+// expands every macro in form. e is a scratch environment that the
+// variables bound in form are added to, so that they hide the macros they
+// share a name with.
+func macroexpandAll(form Obj, e *Env) Obj {
+	form = macroexpand(form, e)
+	pair, ok := form.(*Pair)
+	if !ok {
+		return form
+	}
+	if head, ok := pair.Car.(*Symbol); ok {
+		if value, _ := e.lookup(head); value != nil {
+			if _, ok := value.(Primitive); ok {
+				return expandSpecialForm(*head.s, pair, e)
+			}
+		}
+	}
+	return expandEach(form, e)
+}
+
+// expands each element of a list, leaving any improper tail alone
+func expandEach(o Obj, e *Env) Obj {
+	pair, ok := o.(*Pair)
+	if !ok {
+		return o
+	}
+	return Cons(macroexpandAll(pair.Car, e), expandEach(pair.Cdr, e))
+}
+
+// expands the parts of a special form that are code. special forms it
+// doesn't know about, like ones added from Go, are assumed to evaluate all
+// of their arguments.
+func expandSpecialForm(name string, form *Pair, e *Env) Obj {
+	switch name {
+	case "quote", "defmacro", "syntax-rules", "define-syntax", "let-syntax", "letrec-syntax",
+		"macroexpand", "macroexpand-1", "macroexpand-all":
+		return form
+	case "quasiquote":
+		return Cons(form.Car, expandQuasiquote(form.Cdr, e, 1))
+	case "lambda":
+		args, ok := form.Cdr.(*Pair)
+		if !ok {
+			return form
+		}
+		scope := MakeEnv(e)
+		params, rest := improperListToSlice(args.Car)
+		for _, param := range append(params, rest) {
+			if sym, ok := param.(*Symbol); ok {
+				scope.Bind(sym, Nil)
+			}
+		}
+		return Cons(form.Car, Cons(args.Car, expandEach(args.Cdr, scope)))
+	case "define":
+		args, ok := form.Cdr.(*Pair)
+		if !ok {
+			return form
+		}
+		if sym, ok := args.Car.(*Symbol); ok {
+			e.Bind(sym, Nil)
+		}
+		return Cons(form.Car, Cons(args.Car, expandEach(args.Cdr, e)))
+	case "cond":
+		return Cons(form.Car, expandClauses(form.Cdr, e))
+	case "guard":
+		args, ok := form.Cdr.(*Pair)
+		if !ok {
+			return form
+		}
+		spec, ok := args.Car.(*Pair)
+		if !ok {
+			return form
+		}
+		scope := MakeEnv(e)
+		if sym, ok := spec.Car.(*Symbol); ok {
+			scope.Bind(sym, Nil)
+		}
+		spec = Cons(spec.Car, expandClauses(spec.Cdr, scope))
+		return Cons(form.Car, Cons(spec, expandEach(args.Cdr, e)))
+	}
+	return Cons(form.Car, expandEach(form.Cdr, e))
+}
+
+// expands the expressions in each (test expr ...) clause of a cond or guard
+func expandClauses(o Obj, e *Env) Obj {
+	pair, ok := o.(*Pair)
+	if !ok {
+		return o
+	}
+	return Cons(expandEach(pair.Car, e), expandClauses(pair.Cdr, e))
+}
+
+// expands the unquoted parts of a quasiquote template, which is depth
+// quasiquotes deep
+func expandQuasiquote(o Obj, e *Env, depth int) Obj {
+	pair, ok := o.(*Pair)
+	if !ok {
+		return o
+	}
+	switch {
+	case UnquoteSym.Equal(pair.Car) || UnquoteSplicingSym.Equal(pair.Car):
+		if depth == 1 {
+			return Cons(pair.Car, expandEach(pair.Cdr, e))
+		}
+		return Cons(pair.Car, expandQuasiquote(pair.Cdr, e, depth-1))
+	case QuasiquoteSym.Equal(pair.Car):
+		return Cons(pair.Car, expandQuasiquote(pair.Cdr, e, depth+1))
+	}
+	return Cons(expandQuasiquote(pair.Car, e, depth), expandQuasiquote(pair.Cdr, e, depth))
+}
@@ -0,0 +1,857 @@
+package lisp
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+/*
+Numbers are exact integers, exact rationals, or inexact reals. Integers are
+kept in an int64 while they fit, so that arithmetic on small numbers doesn't
+allocate big.Ints. When a result overflows, it's computed again with
+big.Int, and big results that fit in an int64 go back to being small, so a
+Number holds a big.Int only when it has to. Likewise rationals are never
+whole numbers, which are integers instead.
+
+Arithmetic on numbers of different kinds converts them to the kind further
+down the list first, so exact numbers stay exact until they meet an inexact
+one.
+*/
+
+type numKind uint8
+
+const (
+	kindInteger  numKind = iota // small, or big if it's set
+	kindRational                // rat
+	kindReal                    // float, the only inexact kind
+)
+
+// numbers in this range are made once and shared
+const (
+	minCachedNum = -128
+	maxCachedNum = 1024
+)
+
+var cachedNums = func() []Number {
+	nums := make([]Number, maxCachedNum-minCachedNum+1)
+	for n := range nums {
+		nums[n].small = int64(n + minCachedNum)
+	}
+	return nums
+}()
+
+func MakeInt(n int64) *Number {
+	if n >= minCachedNum && n <= maxCachedNum {
+		return &cachedNums[n-minCachedNum]
+	}
+	return &Number{small: n}
+}
+
+func MakeNum(n *big.Int) *Number {
+	if n == nil {
+		return MakeInt(0)
+	}
+	if n.IsInt64() {
+		return MakeInt(n.Int64())
+	}
+	return &Number{big: n}
+}
+
+// MakeRat makes an exact number, which is an integer if r is a whole number
+func MakeRat(r *big.Rat) *Number {
+	if r.IsInt() {
+		return MakeNum(big.NewInt(0).Set(r.Num()))
+	}
+	return &Number{kind: kindRational, rat: r}
+}
+
+// MakeFloat makes an inexact number
+func MakeFloat(f float64) *Number {
+	return &Number{kind: kindReal, float: f}
+}
+
+func ParseNum(text []byte) *Number {
+	if n, err := strconv.ParseInt(string(text), 0, 64); err == nil {
+		return MakeInt(n)
+	}
+	n := big.NewInt(0)
+	n.UnmarshalText(text)
+	return MakeNum(n)
+}
+
+// parses a number, returning false if s isn't one. Numbers are written as
+// integers like -12, rationals like 1/3, or reals with a decimal point or an
+// exponent like 1.5 and 2e-3, or +inf.0, -inf.0 and +nan.0.
+func parseNum(s string) (*Number, bool) {
+	switch s {
+	case "+inf.0":
+		return MakeFloat(math.Inf(1)), true
+	case "-inf.0":
+		return MakeFloat(math.Inf(-1)), true
+	case "+nan.0", "-nan.0":
+		return MakeFloat(math.NaN()), true
+	}
+	if isInteger(s) {
+		if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return MakeInt(n), true
+		}
+		n, _ := big.NewInt(0).SetString(s, 10)
+		return MakeNum(n), true
+	}
+	if slash := strings.IndexByte(s, '/'); slash >= 0 {
+		num, denom := s[:slash], s[slash+1:]
+		if !isInteger(num) || !isDigits(denom) || strings.Trim(denom, "0") == "" {
+			return nil, false
+		}
+		r, _ := big.NewRat(0, 1).SetString(s)
+		return MakeRat(r), true
+	}
+	if !isDecimal(s) {
+		return nil, false
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	// numbers too big for a float64 come back as infinities
+	if err != nil && !errors.Is(err, strconv.ErrRange) {
+		return nil, false
+	}
+	return MakeFloat(f), true
+}
+
+func isDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
+
+func trimSign(s string) string {
+	if strings.HasPrefix(s, "+") || strings.HasPrefix(s, "-") {
+		return s[1:]
+	}
+	return s
+}
+
+func isInteger(s string) bool {
+	return isDigits(trimSign(s))
+}
+
+// digits with a decimal point, an exponent, or both
+func isDecimal(s string) bool {
+	s = trimSign(s)
+	mantissa, exponent := s, ""
+	if e := strings.IndexAny(s, "eE"); e >= 0 {
+		mantissa, exponent = s[:e], s[e+1:]
+		if !isInteger(exponent) {
+			return false
+		}
+	}
+	point := strings.IndexByte(mantissa, '.')
+	if point < 0 {
+		return exponent != "" && isDigits(mantissa)
+	}
+	whole, fraction := mantissa[:point], mantissa[point+1:]
+	return (whole == "" || isDigits(whole)) && (fraction == "" || isDigits(fraction)) && whole+fraction != ""
+}
+
+// IsExact returns whether the number is an integer or rational rather than
+// an inexact real
+func (n *Number) IsExact() bool {
+	return n.kind != kindReal
+}
+
+// BigInt returns a copy of the value of an exact integer, and nil for any
+// other number
+func (n *Number) BigInt() *big.Int {
+	switch {
+	case n.kind != kindInteger:
+		return nil
+	case n.big == nil:
+		return big.NewInt(n.small)
+	}
+	return big.NewInt(0).Set(n.big)
+}
+
+// Int64 returns the value of an exact integer, and whether it is one that
+// fits in an int64
+func (n *Number) Int64() (int64, bool) {
+	return n.small, n.kind == kindInteger && n.big == nil
+}
+
+// Float64 returns the nearest float64 to the number's value
+func (n *Number) Float64() float64 {
+	switch {
+	case n.kind == kindReal:
+		return n.float
+	case n.kind == kindRational:
+		f, _ := n.rat.Float64()
+		return f
+	case n.big != nil:
+		f, _ := new(big.Float).SetInt(n.big).Float64()
+		return f
+	}
+	return float64(n.small)
+}
+
+// the value of an integer as a big.Int, which must not be modified
+func (n *Number) toBig() *big.Int {
+	if n.big == nil {
+		return big.NewInt(n.small)
+	}
+	return n.big
+}
+
+// the value of an exact number as a big.Rat, which must not be modified
+func (n *Number) toRat() *big.Rat {
+	if n.kind == kindRational {
+		return n.rat
+	}
+	return new(big.Rat).SetInt(n.toBig())
+}
+
+// whether the number is an exact integer, or a real with no fraction
+func (n *Number) isInteger() bool {
+	switch n.kind {
+	case kindInteger:
+		return true
+	case kindReal:
+		return !math.IsInf(n.float, 0) && n.float == math.Trunc(n.float)
+	}
+	return false
+}
+
+func (n *Number) isNaN() bool {
+	return n.kind == kindReal && math.IsNaN(n.float)
+}
+
+func (n *Number) sign() int {
+	switch {
+	case n.kind == kindRational:
+		return n.rat.Sign()
+	case n.kind == kindReal:
+		switch {
+		case n.float < 0:
+			return -1
+		case n.float > 0:
+			return 1
+		}
+		return 0
+	case n.big != nil:
+		return n.big.Sign()
+	case n.small < 0:
+		return -1
+	case n.small > 0:
+		return 1
+	}
+	return 0
+}
+
+func maxKind(a, b *Number) numKind {
+	if a.kind > b.kind {
+		return a.kind
+	}
+	return b.kind
+}
+
+// applies the version of an operation for the kind a and b are converted to
+func arith(a, b *Number, ints func(a, b *Number) *Number, rats func(z, a, b *big.Rat) *big.Rat, floats func(a, b float64) float64) *Number {
+	switch maxKind(a, b) {
+	case kindInteger:
+		return ints(a, b)
+	case kindRational:
+		return MakeRat(rats(new(big.Rat), a.toRat(), b.toRat()))
+	}
+	return MakeFloat(floats(a.Float64(), b.Float64()))
+}
+
+func addNum(a, b *Number) *Number {
+	return arith(a, b, addInt, (*big.Rat).Add, func(a, b float64) float64 { return a + b })
+}
+
+func subNum(a, b *Number) *Number {
+	return arith(a, b, subInt, (*big.Rat).Sub, func(a, b float64) float64 { return a - b })
+}
+
+func mulNum(a, b *Number) *Number {
+	return arith(a, b, mulInt, (*big.Rat).Mul, func(a, b float64) float64 { return a * b })
+}
+
+// exact division of integers makes a rational if they don't divide evenly.
+// b must not be exact zero.
+func divNum(a, b *Number) *Number {
+	return arith(a, b, divInt, (*big.Rat).Quo, func(a, b float64) float64 { return a / b })
+}
+
+func addInt(a, b *Number) *Number {
+	if a.big == nil && b.big == nil {
+		sum := a.small + b.small
+		// overflowed if the sum's sign differs from both of theirs
+		if (sum^a.small)&(sum^b.small) >= 0 {
+			return MakeInt(sum)
+		}
+	}
+	return MakeNum(big.NewInt(0).Add(a.toBig(), b.toBig()))
+}
+
+func subInt(a, b *Number) *Number {
+	if a.big == nil && b.big == nil {
+		diff := a.small - b.small
+		if (a.small^b.small)&(a.small^diff) >= 0 {
+			return MakeInt(diff)
+		}
+	}
+	return MakeNum(big.NewInt(0).Sub(a.toBig(), b.toBig()))
+}
+
+func mulInt(a, b *Number) *Number {
+	if a.big == nil && b.big == nil {
+		if a.small == 0 || b.small == 0 {
+			return MakeInt(0)
+		}
+		product := a.small * b.small
+		if product/b.small == a.small && !(a.small == -1 && b.small == math.MinInt64) && !(b.small == -1 && a.small == math.MinInt64) {
+			return MakeInt(product)
+		}
+	}
+	return MakeNum(big.NewInt(0).Mul(a.toBig(), b.toBig()))
+}
+
+func divInt(a, b *Number) *Number {
+	if a.big == nil && b.big == nil && a.small%b.small == 0 && !(a.small == math.MinInt64 && b.small == -1) {
+		return MakeInt(a.small / b.small)
+	}
+	return MakeRat(new(big.Rat).SetFrac(a.toBig(), b.toBig()))
+}
+
+// Euclidean modulus, like big.Int.Mod, so it's never negative. b must not
+// be zero.
+func modInt(a, b *Number) *Number {
+	if a.big == nil && b.big == nil {
+		r := a.small % b.small
+		if r < 0 {
+			if b.small > 0 {
+				r += b.small
+			} else {
+				r -= b.small
+			}
+		}
+		return MakeInt(r)
+	}
+	return MakeNum(big.NewInt(0).Mod(a.toBig(), b.toBig()))
+}
+
+// modInt for integers that might be inexact, which b must not be zero for
+func modNum(a, b *Number) *Number {
+	if maxKind(a, b) == kindInteger {
+		return modInt(a, b)
+	}
+	x, y := a.Float64(), b.Float64()
+	r := math.Mod(x, y)
+	if r < 0 {
+		r += math.Abs(y)
+	}
+	return MakeFloat(r)
+}
+
+// -1, 0 or 1 as a is less than, equal to or greater than b, comparing
+// exactly even when one of them is inexact. Neither can be NaN.
+func cmpNum(a, b *Number) int {
+	switch {
+	case a.kind == kindInteger && b.kind == kindInteger:
+		if a.big == nil && b.big == nil {
+			switch {
+			case a.small < b.small:
+				return -1
+			case a.small > b.small:
+				return 1
+			}
+			return 0
+		}
+		return a.toBig().Cmp(b.toBig())
+	case a.kind == kindReal && b.kind == kindReal:
+		switch {
+		case a.float < b.float:
+			return -1
+		case a.float > b.float:
+			return 1
+		}
+		return 0
+	case a.kind == kindReal && math.IsInf(a.float, 0):
+		return a.sign()
+	case b.kind == kindReal && math.IsInf(b.float, 0):
+		return -b.sign()
+	}
+	return exactRat(a).Cmp(exactRat(b))
+}
+
+// the exact value of a finite number
+func exactRat(n *Number) *big.Rat {
+	if n.kind == kindReal {
+		return new(big.Rat).SetFloat64(n.float)
+	}
+	return n.toRat()
+}
+
+// whether a and b are the same number with the same exactness, for eq?
+func sameNum(a, b *Number) bool {
+	if a.IsExact() != b.IsExact() {
+		return false
+	}
+	if a.kind == kindReal {
+		return a.float == b.float || a.isNaN() && b.isNaN()
+	}
+	return cmpNum(a, b) == 0
+}
+
+func toInexact(n *Number) *Number {
+	if n.kind == kindReal {
+		return n
+	}
+	return MakeFloat(n.Float64())
+}
+
+func toExact(n *Number) *Number {
+	if n.kind != kindReal {
+		return n
+	}
+	if math.IsInf(n.float, 0) || math.IsNaN(n.float) {
+		panic(MakeError(RangeErrorSym, "inexact->exact needs a finite number", n))
+	}
+	return MakeRat(new(big.Rat).SetFloat64(n.float))
+}
+
+func (n *Number) String() string {
+	switch {
+	case n.kind == kindRational:
+		return n.rat.RatString()
+	case n.kind == kindReal:
+		return formatFloat(n.float)
+	case n.big != nil:
+		return n.big.String()
+	}
+	return strconv.FormatInt(n.small, 10)
+}
+
+// reals print with a decimal point or an exponent, so that they read back
+// as inexact
+func formatFloat(f float64) string {
+	switch {
+	case math.IsNaN(f):
+		return "+nan.0"
+	case math.IsInf(f, 1):
+		return "+inf.0"
+	case math.IsInf(f, -1):
+		return "-inf.0"
+	}
+	s := strconv.FormatFloat(f, 'g', -1, 64)
+	if !strings.ContainsAny(s, ".e") {
+		s += ".0"
+	}
+	return s
+}
+
+// checks that o is a number
+func numberArg(name string, o Obj) *Number {
+	n, ok := o.(*Number)
+	if !ok {
+		panic(MakeError(TypeErrorSym, name+" only takes number arguments", o))
+	}
+	return n
+}
+
+func IsExactPrim(args []Obj, e *Env) Obj {
+	if len(args) != 1 {
+		panic(MakeError(ArityErrorSym, "exact? takes 1 argument"))
+	}
+	return boolToLisp(numberArg("exact?", args[0]).IsExact())
+}
+
+func IsInexactPrim(args []Obj, e *Env) Obj {
+	if len(args) != 1 {
+		panic(MakeError(ArityErrorSym, "inexact? takes 1 argument"))
+	}
+	return boolToLisp(!numberArg("inexact?", args[0]).IsExact())
+}
+
+// true for exact integers and for reals without a fraction, like 2.0
+func IsIntegerPrim(args []Obj, e *Env) Obj {
+	if len(args) != 1 {
+		panic(MakeError(ArityErrorSym, "integer? takes 1 argument"))
+	}
+	n, ok := args[0].(*Number)
+	return boolToLisp(ok && n.isInteger())
+}
+
+func ExactToInexactPrim(args []Obj, e *Env) Obj {
+	if len(args) != 1 {
+		panic(MakeError(ArityErrorSym, "exact->inexact takes 1 argument"))
+	}
+	return toInexact(numberArg("exact->inexact", args[0]))
+}
+
+func InexactToExactPrim(args []Obj, e *Env) Obj {
+	if len(args) != 1 {
+		panic(MakeError(ArityErrorSym, "inexact->exact takes 1 argument"))
+	}
+	return toExact(numberArg("inexact->exact", args[0]))
+}
+
+// checks that o is an integer, exact or not
+func integerArg(name string, o Obj) *Number {
+	n := numberArg(name, o)
+	if !n.isInteger() {
+		panic(MakeError(TypeErrorSym, name+" only takes integer arguments", o))
+	}
+	return n
+}
+
+// compares each adjacent pair of numbers, like compareStrings. NaN isn't
+// equal to, less than or greater than anything.
+func compareNums(name string, args []Obj, ok func(cmp int) bool) Obj {
+	if len(args) < 1 {
+		panic(MakeError(ArityErrorSym, fmt.Sprintf("%v takes at least 1 argument", name)))
+	}
+	prev := numberArg(name, args[0])
+	for _, arg := range args[1:] {
+		curr := numberArg(name, arg)
+		if prev.isNaN() || curr.isNaN() || !ok(cmpNum(prev, curr)) {
+			return False
+		}
+		prev = curr
+	}
+	return True
+}
+
+func oneNumberArg(name string, args []Obj) *Number {
+	if len(args) != 1 {
+		panic(MakeError(ArityErrorSym, name+" takes 1 argument"))
+	}
+	return numberArg(name, args[0])
+}
+
+// the integer arguments of quotient, remainder and modulo
+func divisionArgs(name string, args []Obj) (*Number, *Number) {
+	if len(args) != 2 {
+		panic(MakeError(ArityErrorSym, name+" takes 2 arguments"))
+	}
+	n, d := integerArg(name, args[0]), integerArg(name, args[1])
+	if d.sign() == 0 {
+		panic(MakeError(ArithmeticErrorSym, name+" by zero", n))
+	}
+	return n, d
+}
+
+// rounds toward zero
+func QuotientPrim(args []Obj, e *Env) Obj {
+	n, d := divisionArgs("quotient", args)
+	if maxKind(n, d) == kindReal {
+		return MakeFloat(math.Trunc(n.Float64() / d.Float64()))
+	}
+	if n.big == nil && d.big == nil && !(n.small == math.MinInt64 && d.small == -1) {
+		return MakeInt(n.small / d.small)
+	}
+	return MakeNum(big.NewInt(0).Quo(n.toBig(), d.toBig()))
+}
+
+// has the sign of the dividend, like Go's %
+func RemainderPrim(args []Obj, e *Env) Obj {
+	n, d := divisionArgs("remainder", args)
+	if maxKind(n, d) == kindReal {
+		return MakeFloat(math.Mod(n.Float64(), d.Float64()))
+	}
+	if n.big == nil && d.big == nil {
+		return MakeInt(n.small % d.small)
+	}
+	return MakeNum(big.NewInt(0).Rem(n.toBig(), d.toBig()))
+}
+
+func AbsPrim(args []Obj, e *Env) Obj {
+	n := oneNumberArg("abs", args)
+	if n.sign() < 0 {
+		return mulNum(MakeInt(-1), n)
+	}
+	return n
+}
+
+// the result is inexact if any argument is
+func extremum(name string, args []Obj, keep func(cmp int) bool) Obj {
+	if len(args) < 1 {
+		panic(MakeError(ArityErrorSym, fmt.Sprintf("%v takes at least 1 argument", name)))
+	}
+	result := numberArg(name, args[0])
+	exact := result.IsExact()
+	for _, arg := range args[1:] {
+		n := numberArg(name, arg)
+		exact = exact && n.IsExact()
+		if n.isNaN() || !result.isNaN() && keep(cmpNum(n, result)) {
+			result = n
+		}
+	}
+	if !exact {
+		return toInexact(result)
+	}
+	return result
+}
+
+func MinPrim(args []Obj, e *Env) Obj {
+	return extremum("min", args, func(cmp int) bool { return cmp < 0 })
+}
+
+func MaxPrim(args []Obj, e *Env) Obj {
+	return extremum("max", args, func(cmp int) bool { return cmp > 0 })
+}
+
+// folds the integer arguments with f, which is done exactly and made
+// inexact at the end if any of them were
+func foldIntegers(name string, args []Obj, init int64, f func(a, b *big.Int) *big.Int) Obj {
+	acc := big.NewInt(init)
+	exact := true
+	for _, arg := range args {
+		n := integerArg(name, arg)
+		exact = exact && n.IsExact()
+		acc = f(acc, exactRat(n).Num())
+	}
+	if !exact {
+		return toInexact(MakeNum(acc))
+	}
+	return MakeNum(acc)
+}
+
+func gcd(a, b *big.Int) *big.Int {
+	return big.NewInt(0).GCD(nil, nil, big.NewInt(0).Abs(a), big.NewInt(0).Abs(b))
+}
+
+func GcdPrim(args []Obj, e *Env) Obj {
+	return foldIntegers("gcd", args, 0, gcd)
+}
+
+func LcmPrim(args []Obj, e *Env) Obj {
+	return foldIntegers("lcm", args, 1, func(a, b *big.Int) *big.Int {
+		if a.Sign() == 0 || b.Sign() == 0 {
+			return big.NewInt(0)
+		}
+		lcm := big.NewInt(0).Mul(a, b)
+		lcm.Abs(lcm)
+		return lcm.Quo(lcm, gcd(a, b))
+	})
+}
+
+// exact numbers raised to exact integer powers stay exact
+func ExptPrim(args []Obj, e *Env) Obj {
+	if len(args) != 2 {
+		panic(MakeError(ArityErrorSym, "expt takes 2 arguments"))
+	}
+	base, power := numberArg("expt", args[0]), numberArg("expt", args[1])
+	if !base.IsExact() || power.kind != kindInteger {
+		return MakeFloat(math.Pow(base.Float64(), power.Float64()))
+	}
+
+	if base.sign() == 0 {
+		switch power.sign() {
+		case 0:
+			return MakeInt(1)
+		case -1:
+			panic(MakeError(ArithmeticErrorSym, "division by zero", base))
+		}
+		return base
+	}
+	// anything but 1 and -1 to a power this big won't fit in memory
+	p, ok := power.Int64()
+	if !ok {
+		switch {
+		case cmpNum(base, MakeInt(1)) == 0:
+			return base
+		case cmpNum(base, MakeInt(-1)) == 0:
+			if power.big.Bit(0) == 0 {
+				return MakeInt(1)
+			}
+			return base
+		}
+		panic(MakeError(RangeErrorSym, "expt power is too big", power))
+	}
+
+	abs := p
+	if abs < 0 {
+		abs = -abs
+	}
+	r := base.toRat()
+	num := big.NewInt(0).Exp(r.Num(), big.NewInt(0).SetUint64(uint64(abs)), nil)
+	denom := big.NewInt(0).Exp(r.Denom(), big.NewInt(0).SetUint64(uint64(abs)), nil)
+	if p < 0 {
+		num, denom = denom, num
+	}
+	return MakeRat(new(big.Rat).SetFrac(num, denom))
+}
+
+// returns (s r) where s is the largest integer whose square is at most n,
+// and r is n minus s squared
+func ExactIntegerSqrtPrim(args []Obj, e *Env) Obj {
+	n := oneNumberArg("exact-integer-sqrt", args)
+	if n.kind != kindInteger || n.sign() < 0 {
+		panic(MakeError(TypeErrorSym, "exact-integer-sqrt takes a non-negative exact integer", n))
+	}
+	s := big.NewInt(0).Sqrt(n.toBig())
+	r := big.NewInt(0).Mul(s, s)
+	r.Sub(n.toBig(), r)
+	return List(MakeNum(s), MakeNum(r))
+}
+
+// the square root of an exact number is exact when it can be
+func SqrtPrim(args []Obj, e *Env) Obj {
+	n := oneNumberArg("sqrt", args)
+	if n.sign() < 0 {
+		panic(MakeError(RangeErrorSym, "sqrt of a negative number", n))
+	}
+	if !n.IsExact() {
+		return MakeFloat(math.Sqrt(n.float))
+	}
+	r := n.toRat()
+	num, numOk := exactSqrt(r.Num())
+	denom, denomOk := exactSqrt(r.Denom())
+	if numOk && denomOk {
+		return MakeRat(new(big.Rat).SetFrac(num, denom))
+	}
+	f := new(big.Float).SetPrec(64).SetRat(r)
+	root, _ := f.Sqrt(f).Float64()
+	return MakeFloat(root)
+}
+
+func exactSqrt(n *big.Int) (*big.Int, bool) {
+	s := big.NewInt(0).Sqrt(n)
+	return s, big.NewInt(0).Mul(s, s).Cmp(n) == 0
+}
+
+// rounds rationals to integers with round, and reals with float
+func roundNum(name string, args []Obj, round func(r *big.Rat) *big.Int, float func(f float64) float64) Obj {
+	n := oneNumberArg(name, args)
+	switch n.kind {
+	case kindRational:
+		return MakeNum(round(n.rat))
+	case kindReal:
+		return MakeFloat(float(n.float))
+	}
+	return n
+}
+
+// the quotient of a rational's numerator and denominator, rounded down
+func floorRat(r *big.Rat) *big.Int {
+	q := big.NewInt(0)
+	q.Div(r.Num(), r.Denom()) // Euclidean, and denominators are positive
+	return q
+}
+
+func FloorPrim(args []Obj, e *Env) Obj {
+	return roundNum("floor", args, floorRat, math.Floor)
+}
+
+func CeilingPrim(args []Obj, e *Env) Obj {
+	return roundNum("ceiling", args, func(r *big.Rat) *big.Int {
+		q := floorRat(r)
+		return q.Add(q, big.NewInt(1))
+	}, math.Ceil)
+}
+
+func TruncatePrim(args []Obj, e *Env) Obj {
+	return roundNum("truncate", args, func(r *big.Rat) *big.Int {
+		return big.NewInt(0).Quo(r.Num(), r.Denom())
+	}, math.Trunc)
+}
+
+// rounds halves to even, like R7RS
+func RoundPrim(args []Obj, e *Env) Obj {
+	return roundNum("round", args, func(r *big.Rat) *big.Int {
+		floor := floorRat(r)
+		frac := new(big.Rat).Sub(r, new(big.Rat).SetInt(floor))
+		switch frac.Cmp(big.NewRat(1, 2)) {
+		case 1:
+			return floor.Add(floor, big.NewInt(1))
+		case 0:
+			if floor.Bit(0) == 1 {
+				return floor.Add(floor, big.NewInt(1))
+			}
+		}
+		return floor
+	}, math.RoundToEven)
+}
+
+func IsZeroPrim(args []Obj, e *Env) Obj {
+	return boolToLisp(oneNumberArg("zero?", args).sign() == 0)
+}
+
+func IsPositivePrim(args []Obj, e *Env) Obj {
+	return boolToLisp(oneNumberArg("positive?", args).sign() > 0)
+}
+
+func IsNegativePrim(args []Obj, e *Env) Obj {
+	return boolToLisp(oneNumberArg("negative?", args).sign() < 0)
+}
+
+func IsEvenPrim(args []Obj, e *Env) Obj {
+	if len(args) != 1 {
+		panic(MakeError(ArityErrorSym, "even? takes 1 argument"))
+	}
+	return boolToLisp(exactRat(integerArg("even?", args[0])).Num().Bit(0) == 0)
+}
+
+func IsOddPrim(args []Obj, e *Env) Obj {
+	if len(args) != 1 {
+		panic(MakeError(ArityErrorSym, "odd? takes 1 argument"))
+	}
+	return boolToLisp(exactRat(integerArg("odd?", args[0])).Num().Bit(0) == 1)
+}
+
+// numbers other than decimal ones are written with the digits and letters
+// big.Int uses, and only exact numbers can be
+func formatNum(n *Number, radix int) string {
+	if radix == 10 {
+		return n.String()
+	}
+	switch n.kind {
+	case kindRational:
+		return n.rat.Num().Text(radix) + "/" + n.rat.Denom().Text(radix)
+	case kindReal:
+		panic(MakeError(TypeErrorSym, "number->string can only write inexact numbers in radix 10", n))
+	}
+	return n.toBig().Text(radix)
+}
+
+// parses a number in radix, which can be an integer or a rational unless
+// radix is 10, see parseNum
+func parseNumRadix(s string, radix int) (*Number, bool) {
+	if radix == 10 {
+		return parseNum(s)
+	}
+	num, denom := s, "1"
+	if slash := strings.IndexByte(s, '/'); slash >= 0 {
+		num, denom = s[:slash], s[slash+1:]
+		if strings.HasPrefix(denom, "+") || strings.HasPrefix(denom, "-") {
+			return nil, false
+		}
+	}
+	n, ok := big.NewInt(0).SetString(num, radix)
+	if !ok {
+		return nil, false
+	}
+	d, ok := big.NewInt(0).SetString(denom, radix)
+	if !ok || d.Sign() == 0 {
+		return nil, false
+	}
+	return MakeRat(new(big.Rat).SetFrac(n, d)), true
+}
+
+// the optional radix argument of number->string and string->number
+func radixArg(name string, args []Obj) int {
+	if len(args) < 2 {
+		return 10
+	}
+	n, ok := numberArg(name, args[1]).Int64()
+	if !ok || n < 2 || n > 36 {
+		panic(MakeError(RangeErrorSym, name+" takes a radix between 2 and 36", args[1]))
+	}
+	return int(n)
+}
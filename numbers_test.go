@@ -0,0 +1,152 @@
+package lisp
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"testing"
+)
+
+// the fast paths for small numbers should agree with big.Int, especially
+// around overflow
+func TestSmallNumbersMatchBig(t *testing.T) {
+	values := []int64{0, 1, -1, 2, -2, 3, -7, 1 << 31, -1 << 31, 1<<62 + 1, math.MaxInt64, math.MaxInt64 - 1, math.MinInt64, math.MinInt64 + 1}
+	ops := []struct {
+		name    string
+		small   func(a, b *Number) *Number
+		big     func(z, a, b *big.Int) *big.Int
+		nonZero bool
+	}{
+		{"+", addInt, (*big.Int).Add, false},
+		{"-", subInt, (*big.Int).Sub, false},
+		{"*", mulInt, (*big.Int).Mul, false},
+		{"modulo", modInt, (*big.Int).Mod, true},
+	}
+	for _, op := range ops {
+		for _, a := range values {
+			for _, b := range values {
+				if op.nonZero && b == 0 {
+					continue
+				}
+				got := op.small(MakeInt(a), MakeInt(b))
+				want := op.big(big.NewInt(0), big.NewInt(a), big.NewInt(b))
+				if got.BigInt().Cmp(want) != 0 {
+					t.Errorf("(%v %v %v) = %v, want %v", op.name, a, b, got, want)
+				}
+				if _, small := got.Int64(); small != want.IsInt64() {
+					t.Errorf("(%v %v %v) = %v is small: %v", op.name, a, b, got, small)
+				}
+			}
+		}
+	}
+}
+
+func TestDivideIntegers(t *testing.T) {
+	values := []int64{1, -1, 2, -2, 6, -7, math.MaxInt64, math.MinInt64}
+	for _, a := range values {
+		for _, b := range values {
+			got := divNum(MakeInt(a), MakeInt(b))
+			want := big.NewRat(a, 1)
+			want.Quo(want, big.NewRat(b, 1))
+			if !got.IsExact() || got.toRat().Cmp(want) != 0 {
+				t.Errorf("(/ %v %v) = %v, want %v", a, b, got, want.RatString())
+			}
+			if got.kind == kindRational && want.IsInt() {
+				t.Errorf("(/ %v %v) = %v should be an integer", a, b, got)
+			}
+		}
+	}
+}
+
+func TestReadAndPrintNumbers(t *testing.T) {
+	tests := []struct {
+		text, printed string
+	}{
+		{"12", "12"},
+		{"-12", "-12"},
+		{"+12", "12"},
+		{"123456789012345678901234567890", "123456789012345678901234567890"},
+		{"1/3", "1/3"},
+		{"-2/4", "-1/2"},
+		{"4/2", "2"},
+		{"1.5", "1.5"},
+		{"-.5", "-0.5"},
+		{"1.", "1.0"},
+		{"1e3", "1000.0"},
+		{"2.5E-3", "0.0025"},
+		{"1e400", "+inf.0"},
+		{"+inf.0", "+inf.0"},
+		{"-inf.0", "-inf.0"},
+		{"+nan.0", "+nan.0"},
+	}
+	for _, test := range tests {
+		n, ok := parseNum(test.text)
+		if !ok {
+			t.Errorf("%q didn't parse as a number", test.text)
+			continue
+		}
+		if got := n.String(); got != test.printed {
+			t.Errorf("%q printed as %q, want %q", test.text, got, test.printed)
+		}
+	}
+
+	for _, text := range []string{"-", "+", ".", "...", "1+", "1/0", "1/-2", "e3", "1e", "1.5.2", "inf", "nan", "0x10", "1_000", "-a"} {
+		if n, ok := parseNum(text); ok {
+			t.Errorf("%q parsed as the number %v", text, n)
+		}
+	}
+}
+
+func TestCompareNumbers(t *testing.T) {
+	huge := MakeNum(big.NewInt(0).Lsh(big.NewInt(1), 100))
+	tests := []struct {
+		a, b *Number
+		want int
+	}{
+		{MakeInt(1), MakeInt(2), -1},
+		{MakeInt(2), MakeInt(2), 0},
+		{MakeInt(math.MaxInt64), huge, -1},
+		{huge, MakeInt(math.MinInt64), 1},
+		{huge, MakeNum(big.NewInt(0).Lsh(big.NewInt(1), 100)), 0},
+		{MakeRat(big.NewRat(1, 3)), MakeFloat(0.3333333333333333), 1},
+		{MakeInt(1 << 60), MakeFloat(1 << 60), 0},
+		{MakeInt(1<<60 + 1), MakeFloat(1 << 60), 1},
+		{huge, MakeFloat(math.Inf(1)), -1},
+		{MakeFloat(math.Inf(-1)), MakeInt(math.MinInt64), -1},
+	}
+	for _, test := range tests {
+		if got := cmpNum(test.a, test.b); got != test.want {
+			t.Errorf("cmpNum(%v, %v) = %v, want %v", test.a, test.b, got, test.want)
+		}
+	}
+}
+
+func TestNumericLibrary(t *testing.T) {
+	tests := []struct {
+		expr, want string
+	}{
+		{"(list (< 1 2 3) (< 1 3 2) (> 3 2 1) (<= 1 1 2) (>= 2 2 3))", "(#t #f #t #t #f)"},
+		{"(list (= 1 1.0 1) (= 1 +nan.0) (< 1/3 0.34))", "(#t #f #t)"},
+		{"(list (quotient 7 -2) (quotient -7 2) (remainder -7 2) (remainder 7 -2) (quotient 7.0 2))", "(-3 -3 -1 1 3.0)"},
+		{"(list (abs -5) (abs -1/2) (abs -2.5) (min 1 2.0) (max 1 3 2))", "(5 1/2 2.5 1.0 3)"},
+		{"(list (gcd 12 18) (gcd) (lcm 4 6) (lcm) (gcd -4 6.0))", "(6 0 12 1 2.0)"},
+		{"(list (expt 2 100) (expt 2 -2) (expt 2/3 3) (expt 0 0) (expt 2.0 0.5))", "(1267650600228229401496703205376 1/4 8/27 1 1.4142135623730951)"},
+		{"(list (exact-integer-sqrt 17) (sqrt 16) (sqrt 1/4) (sqrt 2) (sqrt 16.0))", "((4 1) 4 1/2 1.4142135623730951 4.0)"},
+		{"(list (floor -7/2) (ceiling -7/2) (round 7/2) (round 5/2) (round -5/2) (truncate -7/2))", "(-4 -3 4 2 -2 -3)"},
+		{"(list (floor 2.5) (round 2.5) (round 3.5) (truncate -2.5))", "(2.0 2.0 4.0 -2.0)"},
+		{"(list (zero? 0.0) (positive? 1/2) (negative? -1) (even? 4) (odd? 4.0))", "(#t #t #t #t #f)"},
+		{`(list (number->string 255 16) (number->string -10 2) (number->string 1/3 3))`, `("ff" "-1010" "1/10")`},
+		{`(list (string->number "ff" 16) (string->number "-101/11" 2) (string->number "zz" 10))`, "(255 -5/3 #f)"},
+	}
+	interp := New()
+	for _, test := range tests {
+		got, err := interp.EvalString(test.expr)
+		if err != nil {
+			t.Errorf("%v: %v", test.expr, err)
+			continue
+		}
+		if got.(fmt.Stringer).String() != test.want {
+			t.Errorf("%v = %v, want %v", test.expr, got, test.want)
+		}
+	}
+}
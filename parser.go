@@ -1,85 +1,264 @@
-package main
+package lisp
 
 import (
 	"bufio"
-	"bytes"
 	"fmt"
 	"io"
+	"strconv"
 	"strings"
+	"sync"
 	"unicode"
+	"unicode/utf8"
 )
 
 /*
 The parser will use an LL recursive descent parsing strategy
 */
 
-func readRune(s *bufio.Reader) rune {
-	r, _, e := s.ReadRune()
+// Pos is a location in source code
+type Pos struct {
+	File string
+	Line int // starting at 1, 0 if the position is unknown
+	Col  int // starting at 1, counted in characters
+}
+
+func (p Pos) IsValid() bool {
+	return p.Line > 0
+}
+
+func (p Pos) String() string {
+	if p.File == "" {
+		return fmt.Sprintf("%v:%v", p.Line, p.Col)
+	}
+	return fmt.Sprintf("%v:%v:%v", p.File, p.Line, p.Col)
+}
+
+// how many positions a positionTable keeps in each generation
+const maxPositions = 1 << 16
+
+// positionTable records where pairs were read, so that errors can point at
+// the code that caused them. Its entries keep their pairs alive, so it only
+// keeps the ones set or looked up most recently: once it has maxPositions
+// entries it starts again, keeping the full table as an older generation
+// that lookups move entries back from. Readers for the same interpreter can
+// run on several goroutines at once, so it has a lock.
+type positionTable struct {
+	mu            sync.Mutex
+	recent, older map[*Pair]Pos
+}
+
+func makePositionTable() *positionTable {
+	return &positionTable{recent: map[*Pair]Pos{}}
+}
+
+func (t *positionTable) get(p *Pair) Pos {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if pos, ok := t.recent[p]; ok {
+		return pos
+	}
+	pos, ok := t.older[p]
+	if ok {
+		t.add(p, pos)
+	}
+	return pos
+}
+
+func (t *positionTable) set(p *Pair, pos Pos) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.add(p, pos)
+}
+
+func (t *positionTable) add(p *Pair, pos Pos) {
+	if len(t.recent) >= maxPositions {
+		t.older, t.recent = t.recent, map[*Pair]Pos{}
+	}
+	t.recent[p] = pos
+}
+
+// Reader reads Lisp objects from text, interning symbols into an
+// interpreter's symbol table and recording where each list was read
+type Reader struct {
+	s         *bufio.Reader
+	symbols   *SymbolTable
+	positions *positionTable
+	pos       Pos // of the next rune
+	start     Pos // of the last object read
+}
+
+func makeReader(r io.Reader, file string, symbols *SymbolTable, positions *positionTable) *Reader {
+	s, ok := r.(*bufio.Reader)
+	if !ok {
+		s = bufio.NewReader(r)
+	}
+	return &Reader{
+		s:         s,
+		symbols:   symbols,
+		positions: positions,
+		pos:       Pos{File: file, Line: 1, Col: 1},
+	}
+}
+
+// Next reads the next object, returning io.EOF at the end of the input
+func (rd *Reader) Next() (o Obj, err error) {
+	defer func() {
+		r := recover()
+		if r == nil {
+			return
+		}
+		if r == io.EOF {
+			err = io.EOF
+			return
+		}
+		if readErr, ok := r.(*Error); ok {
+			err = readErr
+			return
+		}
+		panic(r)
+	}()
+	o = rd.Read()
+	if o.Type() == TypeCloseParen {
+		rd.errorf(rd.start, "unexpected )")
+	}
+	return o, nil
+}
+
+func readErrorf(pos Pos, format string, args ...interface{}) *Error {
+	err := MakeError(ReadErrorSym, fmt.Sprintf(format, args...))
+	err.Pos = pos
+	return err
+}
+
+// panics with a read error at pos
+func (rd *Reader) errorf(pos Pos, format string, args ...interface{}) {
+	panic(readErrorf(pos, format, args...))
+}
+
+// io.EOF is panicked as-is so callers can tell the end of input apart from
+// read errors
+func (rd *Reader) readError(err error) {
+	if err == io.EOF {
+		panic(io.EOF)
+	}
+	rd.errorf(rd.pos, "%v", err)
+}
+
+func (rd *Reader) readRune() rune {
+	r, _, e := rd.s.ReadRune()
 	if e != nil {
-		panic(e.Error())
+		rd.readError(e)
+	}
+	if r == '\n' {
+		rd.pos.Line++
+		rd.pos.Col = 1
+	} else {
+		rd.pos.Col++
 	}
 	return r
 }
 
-func peekRune(s *bufio.Reader) rune {
-	r, _, e := s.ReadRune()
+func (rd *Reader) peekRune() rune {
+	r, _, e := rd.s.ReadRune()
 	if e != nil {
-		panic(e.Error())
+		rd.readError(e)
 	}
-	s.UnreadRune()
+	rd.s.UnreadRune()
 	return r
 }
 
-func peekN(s *bufio.Reader, n int) []byte {
-	b, err := s.Peek(n)
-	if err != nil {
-		panic(fmt.Sprintf("error peeking %v bytes: %v", n, err))
+// like peekRune, but returns -1 at the end of the input instead of
+// panicking, for tokens that can end there
+func (rd *Reader) peekRuneOrEOF() rune {
+	r, _, e := rd.s.ReadRune()
+	if e == io.EOF {
+		return -1
+	}
+	if e != nil {
+		rd.readError(e)
+	}
+	rd.s.UnreadRune()
+	return r
+}
+
+// returns fewer than n bytes if the input ends first
+func (rd *Reader) peekN(n int) []byte {
+	b, err := rd.s.Peek(n)
+	if err != nil && err != io.EOF {
+		rd.readError(err)
 	}
 	return b
 }
 
-func consumeN(s *bufio.Reader, n int) {
-	_, err := io.CopyN(io.Discard, s, int64(n))
+// for consuming what peekN saw, which must not contain newlines
+func (rd *Reader) consumeN(n int) {
+	_, err := io.CopyN(io.Discard, rd.s, int64(n))
 	if err != nil {
-		panic(fmt.Sprintf("error peeking %v bytes: %v", n, err))
+		rd.readError(err)
+	}
+	rd.pos.Col += n
+}
+
+// the first line of a script is ignored if it starts with #!
+func (rd *Reader) skipShebang() {
+	if string(rd.peekN(2)) != "#!" {
+		return
+	}
+	for rd.peekRuneOrEOF() != -1 && rd.readRune() != '\n' {
+		// consume until newline
 	}
 }
 
-func Read(s *bufio.Reader) Obj {
+// Read panics with io.EOF at the end of the input
+func (rd *Reader) Read() Obj {
 	// things to ignore
-	ReadSpace(s)
-	for ReadComment(s) {
-		ReadSpace(s)
+	rd.ReadSpace()
+	for rd.ReadComment() {
+		rd.ReadSpace()
 	}
 
-	readers := []func(*bufio.Reader) Obj{
-		ReadList,
-		ReadCloseParen,
-		ReadNum,
-		ReadQuote,
-		ReadQuasiquote,
-		ReadUnquoteSplicing, // must be above ReadUnquote
-		ReadUnquote,
-		ReadSym, // this should be at the bottom since it's so permissive
+	readers := []func() Obj{
+		rd.ReadList,
+		rd.ReadVector, // must be above ReadSym, since # starts symbols too
+		rd.ReadHashTable,
+		rd.ReadChar,
+		rd.ReadCloseParen,
+		rd.ReadNum,
+		rd.ReadString,
+		rd.ReadQuote,
+		rd.ReadQuasiquote,
+		rd.ReadUnquoteSplicing, // must be above ReadUnquote
+		rd.ReadUnquote,
+		rd.ReadSym, // this should be at the bottom since it's so permissive
 	}
 
+	start := rd.pos
 	for _, reader := range readers {
-		if o := reader(s); o != nil {
+		if o := reader(); o != nil {
+			// only pairs are recorded, since they're what errors point at.
+			// other objects like symbols and small numbers are shared.
+			if pair, ok := o.(*Pair); ok {
+				rd.positions.set(pair, start)
+			}
+			rd.start = start
 			return o
 		}
 	}
-	panic("bug: unknown syntax encountered while reading")
+	// skip it so the next read can make progress
+	r := rd.readRune()
+	rd.errorf(start, "unknown syntax %q encountered while reading", r)
+	return nil
 }
 
-func ReadSpace(s *bufio.Reader) {
-	for unicode.IsSpace(peekRune(s)) {
-		readRune(s)
+func (rd *Reader) ReadSpace() {
+	for unicode.IsSpace(rd.peekRune()) {
+		rd.readRune()
 	}
 }
 
-func ReadComment(s *bufio.Reader) bool {
-	if peekRune(s) == ';' {
-		for readRune(s) != '\n' {
+func (rd *Reader) ReadComment() bool {
+	if rd.peekRune() == ';' {
+		for rd.readRune() != '\n' {
 			// consume until newline
 		}
 		return true
@@ -87,40 +266,118 @@ func ReadComment(s *bufio.Reader) bool {
 	return false
 }
 
-func ReadQuote(s *bufio.Reader) Obj {
-	r := peekRune(s)
+func (rd *Reader) ReadQuote() Obj {
+	r := rd.peekRune()
 	if r != '\'' {
 		return nil
 	}
-	readRune(s)
-	return Cons(QuoteSym, Cons(Read(s), Nil))
+	rd.readRune()
+	return Cons(QuoteSym, Cons(rd.Read(), Nil))
 }
 
-func ReadQuasiquote(s *bufio.Reader) Obj {
-	r := peekRune(s)
+func (rd *Reader) ReadQuasiquote() Obj {
+	r := rd.peekRune()
 	if r != '`' {
 		return nil
 	}
-	readRune(s)
-	return Cons(QuasiquoteSym, Cons(Read(s), Nil))
+	rd.readRune()
+	return Cons(QuasiquoteSym, Cons(rd.Read(), Nil))
 }
 
-func ReadUnquoteSplicing(s *bufio.Reader) Obj {
-	b := peekN(s, 2)
+func (rd *Reader) ReadUnquoteSplicing() Obj {
+	b := rd.peekN(2)
 	if string(b) != ",@" {
 		return nil
 	}
-	consumeN(s, 2)
-	return Cons(UnquoteSplicingSym, Cons(Read(s), Nil))
+	rd.consumeN(2)
+	return Cons(UnquoteSplicingSym, Cons(rd.Read(), Nil))
 }
 
-func ReadUnquote(s *bufio.Reader) Obj {
-	r := peekRune(s)
+func (rd *Reader) ReadUnquote() Obj {
+	r := rd.peekRune()
 	if r != ',' {
 		return nil
 	}
-	readRune(s)
-	return Cons(UnquoteSym, Cons(Read(s), Nil))
+	rd.readRune()
+	return Cons(UnquoteSym, Cons(rd.Read(), Nil))
+}
+
+func (rd *Reader) ReadString() Obj {
+	if rd.peekRune() != '"' {
+		return nil
+	}
+	start := rd.pos
+	rd.readRune()
+
+	b := strings.Builder{}
+	// bad escapes are reported at the end of the string, so that reading
+	// can carry on after it
+	var escapeErr *Error
+	for {
+		r := rd.readStringRune(start)
+		switch r {
+		case '"':
+			if escapeErr != nil {
+				panic(escapeErr)
+			}
+			return MakeString(b.String())
+		case '\\':
+			escaped, err := rd.readEscape(start)
+			if err != nil && escapeErr == nil {
+				escapeErr = err
+			}
+			b.WriteRune(escaped)
+		default:
+			b.WriteRune(r)
+		}
+	}
+}
+
+// like readRune, but running out of input is an error instead of EOF
+func (rd *Reader) readStringRune(start Pos) rune {
+	if rd.peekRuneOrEOF() == -1 {
+		rd.errorf(start, "unterminated string")
+	}
+	return rd.readRune()
+}
+
+var escapes = map[rune]rune{
+	'n':  '\n',
+	't':  '\t',
+	'r':  '\r',
+	'a':  '\a',
+	'b':  '\b',
+	'0':  0,
+	'"':  '"',
+	'\\': '\\',
+}
+
+// reads the rest of an escape sequence after the backslash, either a single
+// character or a hex scalar value like \x41;
+func (rd *Reader) readEscape(start Pos) (rune, *Error) {
+	escapePos := rd.pos
+	r := rd.readStringRune(start)
+	if escaped, ok := escapes[r]; ok {
+		return escaped, nil
+	}
+	if r != 'x' {
+		return r, readErrorf(escapePos, "unknown string escape \\%c", r)
+	}
+	hex := strings.Builder{}
+	for r = rd.readStringRune(start); r != ';' && r != '"'; r = rd.readStringRune(start) {
+		hex.WriteRune(r)
+	}
+	if r == '"' {
+		// leave the quote to end the string
+		rd.s.UnreadRune()
+		rd.pos.Col--
+		return utf8.RuneError, readErrorf(escapePos, "unterminated hex escape \\x%v", hex.String())
+	}
+	n, err := strconv.ParseUint(hex.String(), 16, 32)
+	if err != nil || !utf8.ValidRune(rune(n)) {
+		return utf8.RuneError, readErrorf(escapePos, "bad hex escape \\x%v;", hex.String())
+	}
+	return rune(n), nil
 }
 
 const symbolChars = "!#$%&*+-./@:<=>?^_"
@@ -129,67 +386,95 @@ func isSymRune(r rune) bool {
 	return unicode.IsLetter(r) || unicode.IsNumber(r) || strings.ContainsRune(symbolChars, r)
 }
 
-func ReadSym(s *bufio.Reader) Obj {
+// symbols that parse as numbers, like -1 and 1.5, are read as numbers, and
+// #t and #f as booleans
+func (rd *Reader) ReadSym() Obj {
 	b := strings.Builder{}
-	for r := peekRune(s); isSymRune(r); r = peekRune(s) {
-		readRune(s)
+	for r := rd.peekRune(); isSymRune(r); r = rd.peekRuneOrEOF() {
+		rd.readRune()
 		b.WriteRune(r)
 	}
 	if b.Len() == 0 {
 		return nil
 	}
-	return Intern(b.String())
+	if n, ok := parseNum(b.String()); ok {
+		return n
+	}
+	switch b.String() {
+	case "#t", "#true":
+		return True
+	case "#f", "#false":
+		return False
+	}
+	return rd.symbols.Intern(b.String())
 }
 
 func isNumRune(r rune) bool {
 	return r >= '0' && r <= '9'
 }
 
-func ReadNum(s *bufio.Reader) Obj {
-	r := peekRune(s)
-	b := bytes.Buffer{}
-	for isNumRune(r) {
-		b.WriteRune(r)
-		readRune(s)
-		r = peekRune(s)
-	}
-	if b.Len() == 0 {
+// numbers are read like symbols, so that 1.5 and 1/2 are read whole, see
+// ReadSym
+func (rd *Reader) ReadNum() Obj {
+	if !isNumRune(rd.peekRune()) {
 		return nil
 	}
-	return ParseNum(b.Bytes())
+	return rd.ReadSym()
 }
 
-func ReadList(s *bufio.Reader) Obj {
-	open := peekRune(s)
+func (rd *Reader) ReadList() Obj {
+	open := rd.peekRune()
 	if open != '(' {
 		return nil
 	}
-	readRune(s)
+	start := rd.pos
+	rd.readRune()
+
+	// so that running out of input in the middle of a list is an error
+	defer func() {
+		if r := recover(); r != nil {
+			if r == io.EOF {
+				rd.errorf(start, "missing close paren")
+			}
+			panic(r)
+		}
+	}()
+
+	// bad elements are reported at the end of the list, so that reading can
+	// carry on after it
+	var elemErr *Error
+	defer func() {
+		if elemErr != nil {
+			panic(elemErr)
+		}
+	}()
 
-	start := Obj(Nil)
-	prev := start
+	head := Obj(Nil)
+	prev := head
 Outer:
 	for {
-		switch curr := Read(s).(type) {
+		switch curr := rd.readElem(&elemErr).(type) {
+		case nil:
+			continue
 		case *CloseParen:
 			break Outer
 		default:
 			if dot, ok := curr.(*Symbol); ok && *dot == *Dot {
-				curr = Read(s)
-				if Nil.Equal(start) {
-					start = curr
+				curr = rd.Read()
+				if Nil.Equal(head) {
+					head = curr
 				}
 				if prevPair, ok := prev.(*Pair); ok && !Nil.Equal(prev) {
 					prevPair.Cdr = curr
 				}
-				if Read(s).Type() != TypeCloseParen {
-					panic("missing close paren after .")
+				if rd.Read().Type() != TypeCloseParen {
+					rd.errorf(start, "missing close paren after .")
 				}
 				break Outer
 			}
 			curr = Cons(curr, Nil)
-			if Nil.Equal(start) {
-				start = curr
+			if Nil.Equal(head) {
+				head = curr
 			}
 			if prevPair, ok := prev.(*Pair); ok && !Nil.Equal(prev) {
 				prevPair.Cdr = curr
@@ -197,12 +482,87 @@ Outer:
 			prev = curr
 		}
 	}
-	return start
+	return head
+}
+
+// #(1 2 3), which is read as a list and copied
+func (rd *Reader) ReadVector() Obj {
+	if string(rd.peekN(2)) != "#(" {
+		return nil
+	}
+	start := rd.pos
+	rd.consumeN(1)
+	items, tail := improperListToSlice(rd.ReadList())
+	if tail != nil {
+		rd.errorf(start, "vectors can't have a . in them")
+	}
+	return MakeVector(items)
+}
+
+// #hash((key . value) ...), and #hasheqv and #hasheq for the other tests,
+// see hashTablePrefixes
+func (rd *Reader) ReadHashTable() Obj {
+	for _, p := range hashTablePrefixes {
+		if string(rd.peekN(len(p.prefix))) != p.prefix {
+			continue
+		}
+		start := rd.pos
+		rd.consumeN(len(p.prefix) - 1)
+		t := MakeHashTable(p.test)
+		for _, entry := range listToSlice(rd.ReadList()) {
+			pair, ok := entry.(*Pair)
+			if !ok {
+				rd.errorf(start, "hash tables are written as (key . value) pairs")
+			}
+			t.set(pair.Car, pair.Cdr)
+		}
+		return t
+	}
+	return nil
+}
+
+// #\a, #\space or #\x41, see parseChar
+func (rd *Reader) ReadChar() Obj {
+	if string(rd.peekN(2)) != `#\` {
+		return nil
+	}
+	start := rd.pos
+	rd.consumeN(2)
+	// the first character can be anything, like the ( in #\(
+	b := strings.Builder{}
+	b.WriteRune(rd.readRune())
+	for r := rd.peekRuneOrEOF(); isSymRune(r); r = rd.peekRuneOrEOF() {
+		rd.readRune()
+		b.WriteRune(r)
+	}
+	r, ok := parseChar(b.String())
+	if !ok {
+		rd.errorf(start, "unknown character #\\%v", b.String())
+	}
+	return MakeChar(r)
+}
+
+// reads the next element of a list, returning nil and saving the first read
+// error in firstErr if there is one
+func (rd *Reader) readElem(firstErr **Error) (o Obj) {
+	defer func() {
+		if r := recover(); r != nil {
+			err, ok := r.(*Error)
+			if !ok {
+				panic(r)
+			}
+			if *firstErr == nil {
+				*firstErr = err
+			}
+			o = nil
+		}
+	}()
+	return rd.Read()
 }
 
-func ReadCloseParen(s *bufio.Reader) Obj {
-	if peekRune(s) == ')' {
-		readRune(s)
+func (rd *Reader) ReadCloseParen() Obj {
+	if rd.peekRune() == ')' {
+		rd.readRune()
 		return &CloseParen{}
 	}
 	return nil
@@ -0,0 +1,86 @@
+package lisp
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+// only lists are given positions, since other objects like small numbers
+// and symbols are shared between everywhere they're read
+func TestPositionsOfSharedObjects(t *testing.T) {
+	i := New()
+	rd := i.NewReader(strings.NewReader("(f 1)\n(g 1)"), "")
+	for n := 0; n < 2; n++ {
+		if _, err := rd.Next(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, o := range []Obj{MakeInt(1), i.Intern("f")} {
+		if pos := i.posOf(o); pos.IsValid() {
+			t.Errorf("%v has position %v", o, pos)
+		}
+	}
+}
+
+// the table keeps a bounded number of positions however much is read, and
+// keeps the ones still being looked up
+func TestPositionTableIsBounded(t *testing.T) {
+	table := makePositionTable()
+	kept := Cons(Nil, Nil)
+	table.set(kept, Pos{Line: 1, Col: 1})
+	for n := 0; n < 10*maxPositions; n++ {
+		table.set(Cons(Nil, Nil), Pos{Line: n + 2, Col: 1})
+		if n%(maxPositions/2) == 0 && !table.get(kept).IsValid() {
+			t.Fatalf("lost the position of a pair being looked up after %v more", n)
+		}
+	}
+	if size := len(table.recent) + len(table.older); size > 2*maxPositions {
+		t.Errorf("table has %v positions", size)
+	}
+}
+
+func TestPositions(t *testing.T) {
+	i := New()
+	rd := i.NewReader(strings.NewReader("; comment\n(a\n  (b c)\n 'd)"), "f.lisp")
+	o, err := rd.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	outer := o.(*Pair)
+	inner := outer.Cdr.(*Pair).Car
+	quoted := outer.Cdr.(*Pair).Cdr.(*Pair).Car
+	for _, test := range []struct {
+		o    Obj
+		want string
+	}{
+		{outer, "f.lisp:2:1"},
+		{inner, "f.lisp:3:3"},
+		{quoted, "f.lisp:4:2"},
+	} {
+		if got := i.posOf(test.o).String(); got != test.want {
+			t.Errorf("%v is at %v, want %v", test.o, got, test.want)
+		}
+	}
+}
+
+// errors say where the form that caused them was read
+func TestErrorPositions(t *testing.T) {
+	tests := []struct {
+		src, want string
+	}{
+		{"(define f\n  (lambda (x)\n    (car x)))\n(f 5)", "in: (car x) at f.lisp:3:5"},
+		// symbols weren't read with a position, so only the backtrace has one
+		{"(list 1\n  (+ 1 undefined-var))", "in: undefined-var\nbacktrace:\n  (+ 1 undefined-var) at f.lisp:2:3"},
+		{"(define x 1)\n(list\n    (+ x\n       (undefined-thing x)))", "in: undefined-thing\nbacktrace:\n  (+ x (undefined-thing x)) at f.lisp:3:5"},
+		{"(list 1\n  2", "missing close paren\n  at f.lisp:1:1"},
+		{"(list 1))", "unexpected )\n  at f.lisp:1:9"},
+	}
+	for _, test := range tests {
+		i := New()
+		_, err := i.evalReader(i.NewReader(strings.NewReader(test.src), "f.lisp"), newBudget(context.Background(), Limits{}))
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%q: got %v, want %v", test.src, err, test.want)
+		}
+	}
+}
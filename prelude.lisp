@@ -1,39 +1,67 @@
 (define list (lambda (. rest) rest))
 
-(defmacro not (a)
-  (if a nil #t))
-
-(defmacro or (a . rest)
-  (if rest
-      (let ((a-sym (gensym)))
-        `(let ((,a-sym ,a)) ; only eval a once
-           (if ,a-sym
-               ,a-sym
-               (or ,(car rest) ,@(cdr rest)))))
-      a))
+(define not
+  (lambda (a)
+    (if a #f #t)))
+
+(define-syntax or
+  (syntax-rules ()
+    ((_) #f)
+    ((_ a) a)
+    ((_ a rest ...)
+     (let ((x a)) ; only eval a once, x can't capture anything in rest
+       (if x x (or rest ...))))))
 
 ;; (let ((a 1) (b 2))
 ;;   (+ a b))
 (defmacro let (bindings body)
-  (if bindings
+  (if (null? bindings)
+      body ; bottom out at the body when no more bindings
       `((lambda (,(car (car bindings))) ; parameter (a)
          (let ,(cdr bindings) ,body))
-       ,(car (cdr (car bindings)))) ; applied parameter (1)
-      body)) ; bottom out at the body when no more bindings
+       ,(car (cdr (car bindings)))))) ; applied parameter (1)
 
 (defmacro begin (. exprs)
   `((lambda () ,@exprs)))
 
 (define map
   (lambda (f ls)
-    (if ls
-        (cons (f (car ls)) (map f (cdr ls)))
-        ls)))
+    (if (null? ls)
+        ls
+        (cons (f (car ls)) (map f (cdr ls))))))
 
 (define filter
   (lambda (f ls)
-    (if ls
+    (if (null? ls)
+        ls
         (if (f (car ls))
             (cons (car ls) (filter f (cdr ls)))
-            (filter f (cdr ls)))
-        ls)))
+            (filter f (cdr ls))))))
+
+;; (member x ls [same?]) is the first tail of ls starting with x, or #f
+(define member
+  (lambda (x ls . same?)
+    (if (null? same?)
+        (member x ls equal?)
+        (if (null? ls)
+            #f
+            (if ((car same?) x (car ls))
+                ls
+                (member x (cdr ls) (car same?)))))))
+
+(define memv (lambda (x ls) (member x ls eqv?)))
+(define memq (lambda (x ls) (member x ls eq?)))
+
+;; (assoc key alist [same?]) is the first pair in alist whose car is key, or #f
+(define assoc
+  (lambda (key alist . same?)
+    (if (null? same?)
+        (assoc key alist equal?)
+        (if (null? alist)
+            #f
+            (if ((car same?) key (car (car alist)))
+                (car alist)
+                (assoc key (cdr alist) (car same?)))))))
+
+(define assv (lambda (key alist) (assoc key alist eqv?)))
+(define assq (lambda (key alist) (assoc key alist eq?)))
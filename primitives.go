@@ -1,33 +1,16 @@
-package main
+package lisp
 
 import (
-	"fmt"
 	"log"
-	"math/big"
-	"os"
+	"reflect"
 )
 
 func LambdaPrim(o Obj, e *Env) Obj {
 	formArgs := listToSlice(o)
 	if len(formArgs) < 2 {
-		panic("lambda takes at least 2 arguments")
+		panic(MakeError(SyntaxErrorSym, "lambda takes at least 2 arguments"))
 	}
-	args, variadic := improperListToSlice(formArgs[0])
-
-	variadicSym, ok := variadic.(*Symbol)
-	if variadic != nil && !ok {
-		panic("arguments must be symbols")
-	}
-
-	argsSyms := []Symbol{}
-	for _, arg := range args {
-		sym, ok := arg.(*Symbol)
-		if !ok {
-			panic("arguments must be symbols")
-		}
-		argsSyms = append(argsSyms, *sym)
-	}
-
+	argsSyms, variadicSym := parseArgs(formArgs[0])
 	body := sliceToList(formArgs[1:])
 
 	if variadicSym != nil {
@@ -39,216 +22,313 @@ func LambdaPrim(o Obj, e *Env) Obj {
 func DefMacroPrim(o Obj, e *Env) Obj {
 	formArgs := listToSlice(o)
 	if len(formArgs) < 3 {
-		panic("defmacro takes at least 3 arguments")
+		panic(MakeError(SyntaxErrorSym, "defmacro takes at least 3 arguments"))
 	}
 
 	name, ok := formArgs[0].(*Symbol)
 	if !ok {
-		panic("name must be a symbol")
+		panic(MakeError(SyntaxErrorSym, "name must be a symbol", formArgs[0]))
+	}
+
+	argsSyms, variadicSym := parseArgs(formArgs[1])
+	body := sliceToList(formArgs[2:])
+
+	var macro *Macro
+	if variadicSym != nil {
+		macro = MakeVariadicMacro(argsSyms, *variadicSym, body, e)
+	} else {
+		macro = MakeMacro(argsSyms, body, e)
 	}
+	macro.name = name
+	return e.Bind(name, macro)
+}
 
-	args, variadic := improperListToSlice(formArgs[1])
+// parses the argument list of a lambda or macro, where a dotted tail is
+// bound to the rest of the arguments
+func parseArgs(o Obj) ([]Symbol, *Symbol) {
+	args, variadic := improperListToSlice(o)
 
 	variadicSym, ok := variadic.(*Symbol)
 	if variadic != nil && !ok {
-		panic("arguments must be symbols")
+		panic(MakeError(SyntaxErrorSym, "arguments must be symbols"))
 	}
 
 	argsSyms := []Symbol{}
 	for _, arg := range args {
 		sym, ok := arg.(*Symbol)
 		if !ok {
-			panic("arguments must be symbols")
+			panic(MakeError(SyntaxErrorSym, "arguments must be symbols"))
 		}
 		argsSyms = append(argsSyms, *sym)
 	}
+	return argsSyms, variadicSym
+}
 
-	body := sliceToList(formArgs[2:])
-
-	proc := Obj(nil)
-	if variadicSym != nil {
-		proc = MakeVariadicMacro(argsSyms, *variadicSym, body, e)
-	} else {
-		proc = MakeMacro(argsSyms, body, e)
+func GensymPrim(args []Obj, e *Env) Obj {
+	if len(args) != 0 {
+		panic(MakeError(ArityErrorSym, "gensym takes no args"))
 	}
-	return e.Bind(name, proc)
+	return e.interp.Gensym()
 }
 
-// only goes one layer deep
-// if i want recursion i basically need another specialized Eval
-func MacroExpandPrim(o Obj, e *Env) Obj {
-	args := listToSlice(o)
+func IsSymbolPrim(args []Obj, e *Env) Obj {
 	if len(args) != 1 {
-		panic("macroexpand takes 1 argument")
-	}
-	expr := listToSlice(args[0])
-	if len(expr) < 1 {
-		panic("macroexpand must be used on a macro expression")
+		panic(MakeError(ArityErrorSym, "symbol? takes 1 argument"))
 	}
-	macro, ok := Eval(expr[0], e).(*Macro)
-	if !ok {
-		panic("macroexpand must be used on a macro expression")
-	}
-	rest := sliceToList(expr[1:])
-
-	return ApplyMacro(macro, rest, e)
-}
-
-func GensymPrim(o Obj, e *Env) Obj {
-	if !Nil.Equal(o) {
-		panic("gensym takes no args")
+	switch args[0].(type) {
+	case *Symbol:
+		return True
+	default:
+		return False
 	}
-	return Gensym()
 }
 
-func IsSymbolPrim(o Obj, e *Env) Obj {
-	args := listToSlice(Evlis(o, e))
+func IsPairPrim(args []Obj, e *Env) Obj {
 	if len(args) != 1 {
-		panic("symbol? takes 1 argument")
+		panic(MakeError(ArityErrorSym, "pair? takes 1 argument"))
 	}
 	switch args[0].(type) {
-	case *Symbol:
+	case *Pair:
 		return True
 	default:
-		return Nil
+		return False
 	}
 }
 
-func IsPairPrim(o Obj, e *Env) Obj {
-	args := listToSlice(Evlis(o, e))
+func IsBooleanPrim(args []Obj, e *Env) Obj {
 	if len(args) != 1 {
-		panic("pair? takes 1 argument")
+		panic(MakeError(ArityErrorSym, "boolean? takes 1 argument"))
 	}
 	switch args[0].(type) {
-	case *Pair:
+	case *Boolean:
 		return True
 	default:
-		return Nil
+		return False
+	}
+}
+
+// whether the argument is the empty list
+func IsNullPrim(args []Obj, e *Env) Obj {
+	if len(args) != 1 {
+		panic(MakeError(ArityErrorSym, "null? takes 1 argument"))
 	}
+	return boolToLisp(Nil.Equal(args[0]))
 }
 
-func IsPrimitivePrim(o Obj, e *Env) Obj {
-	args := listToSlice(Evlis(o, e))
+func IsPrimitivePrim(args []Obj, e *Env) Obj {
 	if len(args) != 1 {
-		panic("primitive? takes 1 argument")
+		panic(MakeError(ArityErrorSym, "primitive? takes 1 argument"))
 	}
 	switch args[0].(type) {
-	case Primitive:
+	case Primitive, *Builtin:
 		return True
 	default:
-		return Nil
+		return False
 	}
 }
 
-func IsProcedurePrim(o Obj, e *Env) Obj {
-	args := listToSlice(Evlis(o, e))
+func IsProcedurePrim(args []Obj, e *Env) Obj {
 	if len(args) != 1 {
-		panic("procedure? takes 1 argument")
+		panic(MakeError(ArityErrorSym, "procedure? takes 1 argument"))
 	}
 	switch args[0].(type) {
 	case *Procedure:
 		return True
 	default:
-		return Nil
+		return False
 	}
 }
 
-func IsMacroPrim(o Obj, e *Env) Obj {
-	args := listToSlice(Evlis(o, e))
+func IsMacroPrim(args []Obj, e *Env) Obj {
 	if len(args) != 1 {
-		panic("macro? takes 1 argument")
+		panic(MakeError(ArityErrorSym, "macro? takes 1 argument"))
 	}
 	switch args[0].(type) {
 	case *Macro:
 		return True
 	default:
-		return Nil
+		return False
 	}
 }
 
-func IsNumberPrim(o Obj, e *Env) Obj {
-	args := listToSlice(Evlis(o, e))
+func IsNumberPrim(args []Obj, e *Env) Obj {
 	if len(args) != 1 {
-		panic("number? takes 1 argument")
+		panic(MakeError(ArityErrorSym, "number? takes 1 argument"))
 	}
 	switch args[0].(type) {
 	case *Number:
 		return True
 	default:
-		return Nil
+		return False
 	}
 }
 
-func EqPrim(o Obj, e *Env) Obj {
-	args := listToSlice(Evlis(o, e))
-
-	v1 := args[0]
-	v2 := args[1]
-
-	if v1.Type() != v2.Type() {
-		return Nil
+func EqPrim(args []Obj, e *Env) Obj {
+	if len(args) != 2 {
+		panic(MakeError(ArityErrorSym, "eq? takes 2 arguments"))
 	}
+	return boolToLisp(eqv(args[0], args[1]))
+}
 
-	switch v1 := v1.(type) {
+// numbers are the same if they have the same value and exactness, and
+// characters if they're the same character. everything
+// else is compared by reference
+func eqv(a, b Obj) bool {
+	if a.Type() != b.Type() {
+		return false
+	}
+	switch a := a.(type) {
 	case *Symbol:
-		return boolToLisp(v1.Equal(v2))
+		return a.Equal(b)
 	case *Number:
-		return boolToLisp(v1.n.Cmp(v2.(*Number).n) == 0)
+		return sameNum(a, b.(*Number))
+	case *Char:
+		return a.r == b.(*Char).r
+	case Primitive:
+		// funcs can't be compared with ==
+		return reflect.ValueOf(a).Pointer() == reflect.ValueOf(b).Pointer()
 	default:
-		// reference equality for misc
-		return boolToLisp(v1 == v2)
+		return a == b
 	}
 }
 
-func LessPrim(o Obj, e *Env) Obj {
-	args := listToSlice(Evlis(o, e))
+// like eqv, but pairs, vectors and strings are compared by their contents
+func equal(a, b Obj) bool {
+	s := equalState{budget: 1000}
+	return s.equal(a, b)
+}
 
-	v1, ok := args[0].(*Number)
-	if !ok {
-		panic("args should be numbers")
+// equal keeps track of the pairs and vectors it's compared once it has
+// looked at budget of them, so that it stops going round cycles made with
+// set-car! and set-cdr!
+type equalState struct {
+	budget int
+	seen   map[[2]Obj]bool
+}
+
+func (s *equalState) equal(a, b Obj) bool {
+	for {
+		switch a1 := a.(type) {
+		case *Pair:
+			b1, ok := b.(*Pair)
+			if !ok {
+				return false
+			}
+			if s.compared(a1, b1) {
+				return true
+			}
+			if !s.equal(a1.Car, b1.Car) {
+				return false
+			}
+			a, b = a1.Cdr, b1.Cdr
+			continue
+		case *Vector:
+			b1, ok := b.(*Vector)
+			if !ok || len(a1.items) != len(b1.items) {
+				return false
+			}
+			if s.compared(a1, b1) {
+				return true
+			}
+			for i := range a1.items {
+				if !s.equal(a1.items[i], b1.items[i]) {
+					return false
+				}
+			}
+			return true
+		case *String:
+			b1, ok := b.(*String)
+			return ok && a1.s == b1.s
+		}
+		return eqv(a, b)
 	}
+}
 
-	v2, ok := args[1].(*Number)
-	if !ok {
-		panic("args should be numbers")
+// whether a and b are already being compared, in which case they can be
+// taken to be equal here, since any difference will be found there
+func (s *equalState) compared(a, b Obj) bool {
+	if s.budget > 0 {
+		s.budget--
+		return false
 	}
+	if s.seen == nil {
+		s.seen = map[[2]Obj]bool{}
+	}
+	key := [2]Obj{a, b}
+	if s.seen[key] {
+		return true
+	}
+	s.seen[key] = true
+	return false
+}
+
+func EqvPrim(args []Obj, e *Env) Obj {
+	if len(args) != 2 {
+		panic(MakeError(ArityErrorSym, "eqv? takes 2 arguments"))
+	}
+	return boolToLisp(eqv(args[0], args[1]))
+}
+
+func EqualPrim(args []Obj, e *Env) Obj {
+	if len(args) != 2 {
+		panic(MakeError(ArityErrorSym, "equal? takes 2 arguments"))
+	}
+	return boolToLisp(equal(args[0], args[1]))
+}
+
+func LessPrim(args []Obj, e *Env) Obj {
+	return compareNums("<", args, func(cmp int) bool { return cmp < 0 })
+}
+
+func GreaterPrim(args []Obj, e *Env) Obj {
+	return compareNums(">", args, func(cmp int) bool { return cmp > 0 })
+}
+
+func LessEqPrim(args []Obj, e *Env) Obj {
+	return compareNums("<=", args, func(cmp int) bool { return cmp <= 0 })
+}
+
+func GreaterEqPrim(args []Obj, e *Env) Obj {
+	return compareNums(">=", args, func(cmp int) bool { return cmp >= 0 })
+}
 
-	return boolToLisp(v1.n.Cmp(v2.n) == -1)
+// numbers are equal if they have the same value, even if one is exact and
+// the other isn't
+func NumEqPrim(args []Obj, e *Env) Obj {
+	return compareNums("=", args, func(cmp int) bool { return cmp == 0 })
 }
 
-func ConsPrim(o Obj, e *Env) Obj {
-	args := listToSlice(Evlis(o, e))
+func ConsPrim(args []Obj, e *Env) Obj {
 	if len(args) != 2 {
 
-		panic("cons takes 2 arguments")
+		panic(MakeError(ArityErrorSym, "cons takes 2 arguments"))
 	}
 	left := args[0]
 	right := args[1]
+	e.interp.allocate(1)
 	return Cons(left, right)
 }
 
-func CarPrim(o Obj, e *Env) Obj {
-	args := listToSlice(Evlis(o, e))
+func CarPrim(args []Obj, e *Env) Obj {
 	if len(args) != 1 {
-		panic("car takes 1 argument")
+		panic(MakeError(ArityErrorSym, "car takes 1 argument"))
 	}
 
 	pair, ok := args[0].(*Pair)
 	if !ok {
-		panic("car takes pairs as arguments")
+		panic(MakeError(TypeErrorSym, "car takes pairs as arguments", args[0]))
 	}
 	return Car(pair)
 }
 
-func CdrPrim(o Obj, e *Env) Obj {
-	args := listToSlice(Evlis(o, e))
+func CdrPrim(args []Obj, e *Env) Obj {
 	if len(args) != 1 {
-		panic("cdr takes 1 argument")
+		panic(MakeError(ArityErrorSym, "cdr takes 1 argument"))
 	}
 
 	pair, ok := args[0].(*Pair)
 	if !ok {
-		panic("cdr takes pairs as arguments")
+		panic(MakeError(TypeErrorSym, "cdr takes pairs as arguments", args[0]))
 	}
 	return Cdr(pair)
 }
@@ -256,61 +336,66 @@ func CdrPrim(o Obj, e *Env) Obj {
 func DefinePrim(o Obj, e *Env) Obj {
 	args := listToSlice(o)
 	if len(args) != 2 {
-		panic("define takes 2 arguments")
+		panic(MakeError(SyntaxErrorSym, "define takes 2 arguments"))
 	}
 
 	name, ok := args[0].(*Symbol)
 	if !ok {
-		panic("the first argument to define is a symbol")
+		panic(MakeError(SyntaxErrorSym, "the first argument to define is a symbol", args[0]))
 	}
 
 	expr := args[1]
-	return e.Bind(name, Eval(expr, e))
+	value := Eval(expr, e)
+	// name anonymous procedures for backtraces
+	if proc, ok := value.(*Procedure); ok && proc.name == nil {
+		proc.name = name
+	}
+	return e.Bind(name, value)
 }
 
 func SetPrim(o Obj, e *Env) Obj {
 	args := listToSlice(o)
 	if len(args) != 2 {
-		panic("set takes 2 arguments")
+		panic(MakeError(SyntaxErrorSym, "set takes 2 arguments"))
 	}
 
 	name, ok := args[0].(*Symbol)
 	if !ok {
-		panic(fmt.Sprintf("the first argument to set is a symbol, not %T (%v)", args[0], args[0].(fmt.Stringer).String()))
+		panic(MakeError(SyntaxErrorSym, "the first argument to set is a symbol", args[0]))
 	}
 
 	expr := args[1]
 	return e.Set(name, Eval(expr, e))
 }
 
-func SetCarPrim(o Obj, e *Env) Obj {
-	args := listToSlice(Evlis(o, e))
+func SetCarPrim(args []Obj, e *Env) Obj {
 	if len(args) != 2 {
-		panic("set-car! takes 2 arguments")
+		panic(MakeError(ArityErrorSym, "set-car! takes 2 arguments"))
 	}
 
 	pair, ok := args[0].(*Pair)
 	if !ok {
-		panic(fmt.Sprintf("the first argument to set-car is a pair, not %T (%v)", args[0], args[0].(fmt.Stringer).String()))
+		panic(MakeError(TypeErrorSym, "the first argument to set-car is a pair", args[0]))
 	}
 
+	e.interp.changing(pair)
 	newVal := args[1]
 	oldVal := pair.Car
 	pair.Car = newVal
 	return oldVal
 }
 
-func SetCdrPrim(o Obj, e *Env) Obj {
-	args := listToSlice(Evlis(o, e))
+func SetCdrPrim(args []Obj, e *Env) Obj {
 	if len(args) != 2 {
-		panic("set-cdr! takes 2 arguments")
+		panic(MakeError(ArityErrorSym, "set-cdr! takes 2 arguments"))
 	}
 
 	pair, ok := args[0].(*Pair)
 	if !ok {
-		panic(fmt.Sprintf("the first argument to set-cdr is a pair, not %T (%v)", args[0], args[0].(fmt.Stringer).String()))
+		panic(MakeError(TypeErrorSym, "the first argument to set-cdr is a pair", args[0]))
 	}
 
+	e.interp.changing(pair)
 	newVal := args[1]
 	oldVal := pair.Cdr
 	pair.Cdr = newVal
@@ -322,40 +407,40 @@ func IfPrim(o Obj, e *Env) Obj {
 	if len(args) == 2 {
 		test := Eval(args[0], e)
 		expr1 := args[1]
-		if !Nil.Equal(test) {
-			return Eval(expr1, e)
+		if !e.interp.isFalse(test) {
+			return MakeTailCall(expr1, e)
 		}
 		return Nil
 	} else if len(args) == 3 {
 		test := Eval(args[0], e)
 		expr1 := args[1]
 		expr2 := args[2]
-		if !Nil.Equal(test) {
-			return Eval(expr1, e)
+		if !e.interp.isFalse(test) {
+			return MakeTailCall(expr1, e)
 		}
-		return Eval(expr2, e)
+		return MakeTailCall(expr2, e)
 	} else {
-		panic(fmt.Sprintf("if takes 2 or 3 arguments, given %v", o.(fmt.Stringer).String()))
+		panic(MakeError(SyntaxErrorSym, "if takes 2 or 3 arguments", o))
 	}
 }
 
 func CondPrim(o Obj, e *Env) Obj {
 	args := listToSlice(o)
 	if len(args) < 1 {
-		panic("cond takes at least 1 argument")
+		panic(MakeError(SyntaxErrorSym, "cond takes at least 1 argument"))
 	}
 	for _, cases := range args {
 		cases := listToSlice(cases)
 		if len(cases) != 2 {
-			panic("each cond case should have a predicate and a body")
+			panic(MakeError(SyntaxErrorSym, "each cond case should have a predicate and a body"))
 		}
 		pred := cases[0]
 		body := cases[1]
-		if Else.Equal(pred) {
-			return Eval(body, e)
+		if isElse(pred) {
+			return MakeTailCall(body, e)
 		}
-		if !Nil.Equal(Eval(pred, e)) {
-			return Eval(body, e)
+		if !e.interp.isFalse(Eval(pred, e)) {
+			return MakeTailCall(body, e)
 		}
 	}
 	return Nil
@@ -364,7 +449,7 @@ func CondPrim(o Obj, e *Env) Obj {
 func QuotePrim(o Obj, _ *Env) Obj {
 	args := listToSlice(o)
 	if len(args) != 1 {
-		panic("quote takes 1 argument")
+		panic(MakeError(SyntaxErrorSym, "quote takes 1 argument"))
 	}
 	return args[0]
 }
@@ -372,7 +457,7 @@ func QuotePrim(o Obj, _ *Env) Obj {
 func QuasiquotePrim(o Obj, e *Env) Obj {
 	args := listToSlice(o)
 	if len(args) != 1 {
-		panic("quasiquote takes 1 argument")
+		panic(MakeError(SyntaxErrorSym, "quasiquote takes 1 argument"))
 	}
 	return Quasiquote(args[0], e)
 }
@@ -382,7 +467,7 @@ func Quasiquote(o Obj, e *Env) Obj {
 	if !ok {
 		if UnquoteSym.Equal(o) {
 			// since we can't catch it earlier
-			panic("unquote takes 2 args")
+			panic(MakeError(SyntaxErrorSym, "unquote takes 2 args"))
 		}
 		return o
 	}
@@ -395,13 +480,14 @@ func Quasiquote(o Obj, e *Env) Obj {
 		if isUnquoteSplicing(elem) {
 			splicing := listToSlice(elem)
 			if len(splicing) != 2 {
-				panic("unquote-splicing takes 2 args")
+				panic(MakeError(SyntaxErrorSym, "unquote-splicing takes 2 args"))
 			}
 			out = append(out, listToSlice(Eval(splicing[1], e))...)
 		} else {
 			out = append(out, Quasiquote(elem, e))
 		}
 	}
+	e.interp.allocate(len(out))
 	return sliceToList(out)
 }
 
@@ -417,153 +503,135 @@ func isUnquoteSplicing(o Obj) bool {
 // make a constructor in types.go
 // should be un-interned, only used in macros
 
-func EvalPrim(o Obj, e *Env) Obj {
-	args := listToSlice(Evlis(o, e))
+func EvalPrim(args []Obj, e *Env) Obj {
 	if len(args) != 1 {
-		panic("eval takes 1 argument")
+		panic(MakeError(ArityErrorSym, "eval takes 1 argument"))
 	}
-	return Eval(args[0], e)
+	return MakeTailCall(args[0], e)
 }
 
-func ApplyPrim(o Obj, e *Env) Obj {
-	args := Evlis(o, e)
-	pair, ok := args.(*Pair)
-	if !ok {
-		panic("apply takes 2 arguments")
+func ApplyPrim(args []Obj, e *Env) Obj {
+	if len(args) < 2 {
+		panic(MakeError(ArityErrorSym, "apply takes 2 arguments"))
 	}
-	proc := Car(pair)
-	pair, ok = Cdr(pair).(*Pair)
-	if !ok {
-		panic("apply takes 2 arguments")
-	}
-	return Apply(proc, Car(pair), e)
-
+	return MakeTailApply(args[0], listToSlice(args[1]), e)
 }
 
-func AddPrim(o Obj, e *Env) Obj {
-	acc := big.NewInt(0)
-	args := listToSlice(Evlis(o, e))
-	if len(args) == 0 {
-		return MakeNum(acc)
-	}
-	for _, n := range args {
-		n, ok := n.(*Number)
+func AddPrim(args []Obj, e *Env) Obj {
+	acc := MakeInt(0)
+	for _, arg := range args {
+		n, ok := arg.(*Number)
 		if !ok {
-			panic("+ only takes number arguments")
+			panic(MakeError(TypeErrorSym, "+ only takes number arguments", arg))
 		}
 
-		acc.Add(acc, n.n)
+		acc = addNum(acc, n)
 	}
-	return MakeNum(acc)
+	return acc
 }
 
-func SubPrim(o Obj, e *Env) Obj {
-	acc := big.NewInt(0)
-	args := listToSlice(Evlis(o, e))
-	if len(args) == 0 {
-		return MakeNum(acc)
-	}
-	for i, n := range args {
-		n, ok := n.(*Number)
+func SubPrim(args []Obj, e *Env) Obj {
+	acc := MakeInt(0)
+	for i, arg := range args {
+		n, ok := arg.(*Number)
 		if !ok {
-			panic("- only takes number arguments")
+			panic(MakeError(TypeErrorSym, "- only takes number arguments", arg))
 		}
 
 		// first element is minuend, following are subtrahend (i googled this lol)
 		if i == 0 {
-			acc.SetBytes(n.n.Bytes())
+			acc = n
 		} else {
-			acc.Sub(acc, n.n)
+			acc = subNum(acc, n)
 		}
 	}
 	// special case: unary minus is negation
 	if len(args) == 1 {
-		return MakeNum(acc.Neg(acc))
+		return mulNum(MakeInt(-1), acc)
 	}
-	return MakeNum(acc)
+	return acc
 }
 
-func MulPrim(o Obj, e *Env) Obj {
-	acc := big.NewInt(1)
-	args := listToSlice(Evlis(o, e))
-	if len(args) == 0 {
-		return MakeNum(acc)
-	}
-	for _, n := range args {
-		n, ok := n.(*Number)
+func MulPrim(args []Obj, e *Env) Obj {
+	acc := MakeInt(1)
+	for _, arg := range args {
+		n, ok := arg.(*Number)
 		if !ok {
-			panic("* only takes number arguments")
+			panic(MakeError(TypeErrorSym, "* only takes number arguments", arg))
 		}
 
-		acc.Mul(acc, n.n)
+		acc = mulNum(acc, n)
 	}
-	return MakeNum(acc)
+	return acc
 }
 
-func DivPrim(o Obj, e *Env) Obj {
-	acc := big.NewInt(1)
-	args := listToSlice(Evlis(o, e))
-	if len(args) == 0 {
-		return MakeNum(acc)
-	}
-	for i, n := range args {
-		n, ok := n.(*Number)
+func DivPrim(args []Obj, e *Env) Obj {
+	acc := MakeInt(1)
+	for i, arg := range args {
+		n, ok := arg.(*Number)
 		if !ok {
-			panic("/ only takes number arguments")
+			panic(MakeError(TypeErrorSym, "/ only takes number arguments", arg))
 		}
 
 		// first element is divident, following are divisors
 		if i == 0 {
-			acc.SetBytes(n.n.Bytes())
+			acc = n
 		} else {
-			acc.Div(acc, n.n)
+			if n.IsExact() && n.sign() == 0 {
+				panic(MakeError(ArithmeticErrorSym, "division by zero", acc))
+			}
+			acc = divNum(acc, n)
+		}
+	}
+	// special case: unary division is the reciprocal
+	if len(args) == 1 {
+		if acc.IsExact() && acc.sign() == 0 {
+			panic(MakeError(ArithmeticErrorSym, "division by zero", MakeInt(1)))
 		}
+		return divNum(MakeInt(1), acc)
 	}
-	return MakeNum(acc)
+	return acc
 }
 
-func ModuloPrim(o Obj, e *Env) Obj {
-	args := listToSlice(Evlis(o, e))
+func ModuloPrim(args []Obj, e *Env) Obj {
 	if len(args) != 2 {
-		panic("modulo takes 2 args")
+		panic(MakeError(ArityErrorSym, "modulo takes 2 args"))
 	}
 
 	v1, ok := args[0].(*Number)
-	if !ok {
-		panic("modulo only takes number arguments")
+	if !ok || !v1.isInteger() {
+		panic(MakeError(TypeErrorSym, "modulo only takes integer arguments", args[0]))
 	}
 	v2, ok := args[1].(*Number)
-	if !ok {
-		panic("modulo only takes number arguments")
+	if !ok || !v2.isInteger() {
+		panic(MakeError(TypeErrorSym, "modulo only takes integer arguments", args[1]))
 	}
-
-	// mod MUTATES, so make a new bigint
-	result := big.NewInt(0).Mod(v1.n, v2.n)
-	return MakeNum(result)
+	if v2.sign() == 0 {
+		panic(MakeError(ArithmeticErrorSym, "modulo by zero", v1))
+	}
+	return modNum(v1, v2)
 }
 
-func ExitPrim(o Obj, e *Env) Obj {
-	args := listToSlice(Evlis(o, e))
+func ExitPrim(args []Obj, e *Env) Obj {
 	if len(args) > 1 {
-		panic("exit takes 1 or 0 arguments")
+		panic(MakeError(ArityErrorSym, "exit takes 1 or 0 arguments"))
 	}
 	if len(args) == 0 {
-		os.Exit(0)
+		panic(&ExitError{Code: 0})
 	}
 	n, ok := args[0].(*Number)
 	if !ok {
-		panic("exit take a number for an argument")
+		panic(MakeError(TypeErrorSym, "exit take a number for an argument", args[0]))
 	}
-	os.Exit(int(n.n.Int64()))
-	return Nil
+	code, _ := n.Int64()
+	panic(&ExitError{Code: int(code)})
 }
 
-func PrintPrim(o Obj, e *Env) Obj {
-	args := listToSlice(Evlis(o, e))
+func PrintPrim(args []Obj, e *Env) Obj {
 	if len(args) != 1 {
-		panic("print takes 1 argument")
+		panic(MakeError(ArityErrorSym, "print takes 1 argument"))
 	}
-	Print(args[0])
+	e.interp.print(args[0])
 	return Nil
 }
 
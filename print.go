@@ -1,66 +1,269 @@
-package main
+package lisp
 
 import (
 	"fmt"
 	"log"
 	"strings"
+	"unicode"
 )
 
+func (b *Boolean) String() string {
+	if b.value {
+		return "#t"
+	}
+	return "#f"
+}
+
+func (*EmptyList) String() string {
+	return "()"
+}
+
 func (s *Symbol) String() string {
 	return *s.s
 }
 
-func (n *Number) String() string {
-	return n.n.String()
+// strings print with quotes and escapes so they can be read back in
+func (s *String) String() string {
+	b := strings.Builder{}
+	b.WriteByte('"')
+	for _, r := range s.s {
+		switch r {
+		case '"':
+			b.WriteString(`\"`)
+		case '\\':
+			b.WriteString(`\\`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\t':
+			b.WriteString(`\t`)
+		case '\r':
+			b.WriteString(`\r`)
+		default:
+			if unicode.IsControl(r) {
+				fmt.Fprintf(&b, `\x%x;`, r)
+			} else {
+				b.WriteRune(r)
+			}
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
 }
 
 func (Primitive) String() string {
 	return "#<primitive>"
 }
 
+func (*Builtin) String() string {
+	return "#<primitive>"
+}
+
 func (p Procedure) String() string {
-	return fmt.Sprintf("#<procedure: args=%v body=%v variadic=%v>", p.args, p.body, p.variadic)
+	args := symbolNames(p.args)
+	if p.name != nil {
+		return fmt.Sprintf("#<procedure %v: args=%v body=%v variadic=%v>", p.name, args, p.body, p.variadic)
+	}
+	return fmt.Sprintf("#<procedure: args=%v body=%v variadic=%v>", args, p.body, p.variadic)
 }
 
 func (p Macro) String() string {
-	return fmt.Sprintf("#<macro: args=%v body=%v variadic=%v>", p.args, p.body, p.variadic)
+	if p.rules != nil && p.name != nil {
+		return fmt.Sprintf("#<macro %v: syntax-rules>", p.name)
+	} else if p.rules != nil {
+		return "#<macro: syntax-rules>"
+	}
+	args := symbolNames(p.args)
+	if p.name != nil {
+		return fmt.Sprintf("#<macro %v: args=%v body=%v variadic=%v>", p.name, args, p.body, p.variadic)
+	}
+	return fmt.Sprintf("#<macro: args=%v body=%v variadic=%v>", args, p.body, p.variadic)
+}
+
+// Symbols print as structs in a slice, since String is on *Symbol
+func symbolNames(syms []Symbol) []string {
+	names := make([]string, len(syms))
+	for n := range syms {
+		names[n] = syms[n].String()
+	}
+	return names
+}
+
+func (err *Error) String() string {
+	return toString(err)
+}
+
+// kind: message irritant...
+// the kind is left out for errors raised with the error procedure
+func (err *Error) summary() string {
+	p := printer{}
+	p.summary(err)
+	return p.b.String()
 }
 
 func (p *Pair) String() string {
-	b := strings.Builder{}
-	b.WriteByte('(')
-
-	firstElem := true
-	curr := Obj(p)
-	for curr.Type() == TypePair {
-		pair := curr.(*Pair)
-		if !firstElem {
-			b.WriteByte(' ')
+	return toString(p)
+}
+
+func (v *Vector) String() string {
+	return toString(v)
+}
+
+func toString(o Obj) string {
+	p := printer{}
+	p.print(o)
+	return p.b.String()
+}
+
+// printer writes the printed representation of objects that can hold
+// others. It keeps track of the ones it's inside, like equalState, so that
+// cycles made with set-cdr! and the like are printed as #<cycle> instead of
+// going round them forever. With display set, strings and characters are
+// written as they are, all the way down, rather than so they can be read
+// back in.
+type printer struct {
+	b       strings.Builder
+	inside  map[Obj]bool
+	display bool
+}
+
+func (p *printer) print(o Obj) {
+	switch o := o.(type) {
+	case *Pair:
+		p.pair(o)
+	case *Vector:
+		p.vector(o)
+	case *HashTable:
+		p.hashTable(o)
+	case *Error:
+		p.error(o)
+	case *String:
+		if p.display {
+			p.b.WriteString(o.s)
 		} else {
-			firstElem = false
+			p.b.WriteString(o.String())
 		}
+	case *Char:
+		if p.display {
+			p.b.WriteRune(o.r)
+		} else {
+			p.b.WriteString(o.String())
+		}
+	default:
+		p.b.WriteString(mustStringer(o).String())
+	}
+}
 
-		b.WriteString(mustStringer(Car(pair)).String())
-		curr = Cdr(pair)
+// marks o as being printed, returning false if it already is
+func (p *printer) enter(o Obj) bool {
+	if p.inside[o] {
+		p.b.WriteString("#<cycle>")
+		return false
+	}
+	if p.inside == nil {
+		p.inside = map[Obj]bool{}
+	}
+	p.inside[o] = true
+	return true
+}
+
+func (p *printer) leave(o Obj) {
+	delete(p.inside, o)
+}
+
+func (p *printer) pair(pair *Pair) {
+	if !p.enter(pair) {
+		return
+	}
+	p.b.WriteByte('(')
+	p.print(pair.Car)
+	curr, entered := pair.Cdr, 0
+	for {
+		next, ok := curr.(*Pair)
+		if !ok || p.inside[next] {
+			break
+		}
+		p.inside[next] = true
+		entered++
+		p.b.WriteByte(' ')
+		p.print(next.Car)
+		curr = next.Cdr
 	}
 	if !Nil.Equal(curr) {
-		b.WriteString(" . ")
-		b.WriteString(mustStringer(curr).String())
+		p.b.WriteString(" . ")
+		p.print(curr)
+	}
+	p.b.WriteByte(')')
+	p.leave(pair)
+	for rest := pair.Cdr; entered > 0; entered-- {
+		p.leave(rest)
+		rest = rest.(*Pair).Cdr
+	}
+}
+
+func (p *printer) vector(v *Vector) {
+	if !p.enter(v) {
+		return
+	}
+	p.b.WriteString("#(")
+	for i, item := range v.items {
+		if i > 0 {
+			p.b.WriteByte(' ')
+		}
+		p.print(item)
+	}
+	p.b.WriteByte(')')
+	p.leave(v)
+}
+
+func (p *printer) error(err *Error) {
+	if !p.enter(err) {
+		return
+	}
+	p.b.WriteString("#<error ")
+	p.summary(err)
+	p.b.WriteByte('>')
+	p.leave(err)
+}
+
+func (p *printer) summary(err *Error) {
+	if !ErrorSym.Equal(err.Kind) {
+		p.b.WriteString(err.Kind.String())
+		p.b.WriteString(": ")
+	}
+	p.b.WriteString(err.Message)
+	for _, irritant := range listToSlice(err.Irritants) {
+		p.b.WriteByte(' ')
+		p.print(irritant)
 	}
-	b.WriteByte(')')
-	return b.String()
 }
 
 var _ fmt.Stringer = &Symbol{}
 var _ fmt.Stringer = &Pair{}
+var _ fmt.Stringer = &Vector{}
 var _ fmt.Stringer = Primitive(nil)
+var _ fmt.Stringer = (*Builtin)(nil)
 var _ fmt.Stringer = &Procedure{}
+var _ fmt.Stringer = &Error{}
+var _ fmt.Stringer = &String{}
 
 // see above for a list of supported types
 func Print(o Obj) {
 	fmt.Println(mustStringer(o))
 }
 
+// like Print, to the interpreter's output
+func (i *Interpreter) print(o Obj) {
+	fmt.Fprintln(i.out, mustStringer(o))
+}
+
+// the human readable form of an object, as opposed to its printed
+// representation. used for error messages.
+func displayString(o Obj) string {
+	p := printer{display: true}
+	p.print(o)
+	return p.b.String()
+}
+
 // exit on any bugs, all user exposed types should be printable
 func mustStringer(o Obj) fmt.Stringer {
 	s, ok := o.(fmt.Stringer)
@@ -0,0 +1,48 @@
+package lisp
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// objects that contain themselves print the way back in as #<cycle>
+func TestPrintCycles(t *testing.T) {
+	tests := []struct {
+		src, want string
+	}{
+		{`(define l (list 1 2 3)) (set-cdr! (cdr (cdr l)) l) l`, `(1 2 3 . #<cycle>)`},
+		{`(define l (list 1 2)) (set-car! (cdr l) l) l`, `(1 #<cycle>)`},
+		{`(define v (vector 1 2)) (vector-set! v 1 v) (list v v)`, `(#(1 #<cycle>) #(1 #<cycle>))`},
+		{`(define v (vector 1 (list 2))) (set-cdr! (vector-ref v 1) v) v`, `#(1 (2 . #<cycle>))`},
+		{`(define h (make-hash-table)) (hash-table-set! h h 1) h`, `#hash((#<cycle> . 1))`},
+		{`(define h (make-hash-table 'eq?)) (hash-table-set! h 'me (list h)) h`, `#hasheq((me #<cycle>))`},
+		// shared parts that aren't cycles are printed in full
+		{`(define s (list 1 2)) (list s s (vector s s))`, `((1 2) (1 2) #((1 2) (1 2)))`},
+	}
+	for _, test := range tests {
+		result, err := New().EvalString(test.src)
+		if got := fmt.Sprint(result); err != nil || got != test.want {
+			t.Errorf("%v: got %v, %v, want %v", test.src, got, err, test.want)
+		}
+	}
+}
+
+// display writes strings and characters as they are, wherever they are
+func TestDisplay(t *testing.T) {
+	tests := []struct {
+		src, want string
+	}{
+		{`(display "s")`, `s`},
+		{`(display (list #\a "s" 'sym))`, `(a s sym)`},
+		{`(display (vector "x" (list #\space "y")))`, `#(x (  y))`},
+		{`(display (cons "a" "b"))`, `(a . b)`},
+		{`(write (list #\a "s"))`, `(#\a "s")`},
+	}
+	for _, test := range tests {
+		out := strings.Builder{}
+		if _, err := New(WithOutput(&out)).EvalString(test.src); err != nil || out.String() != test.want {
+			t.Errorf("%v: got %q, %v, want %q", test.src, out.String(), err, test.want)
+		}
+	}
+}
@@ -0,0 +1,242 @@
+package lisp
+
+import "runtime"
+
+// prompt is a call-with-prompt that's running. Its body runs on a goroutine
+// of its own so that abort-to-prompt can suspend it, and the continuation
+// passed to the handler can resume it later. Only one goroutine runs Lisp
+// code at a time: whoever starts or resumes a body waits until it finishes
+// or suspends again.
+type prompt struct {
+	tag, handler Obj
+	outer        *prompt // the prompt around this one, nil at the top level
+	body         *body
+}
+
+type body struct {
+	resume chan resumption // to the body, when it's suspended
+	events chan event      // from the body, when it finishes or suspends
+}
+
+type resumption struct {
+	value  Obj
+	cancel bool // unwinds the body, as its continuation is gone
+}
+
+// what a body did when it last ran. abort is set if it suspended itself,
+// and panic is set if it finished by panicking.
+type event struct {
+	value Obj
+	panic interface{}
+	abort *abort
+}
+
+type abort struct {
+	tag  Obj
+	args []Obj
+}
+
+// abandoned is panicked with to unwind a body whose continuation was
+// garbage collected. Nothing recovers it apart from the body itself.
+type abandoned struct{}
+
+// the parts of the interpreter that change as code runs, which are switched
+// over when a body is started, suspended or resumed
+type dynamicState struct {
+	stack    []frame
+	handlers []Obj
+	prompt   *prompt
+}
+
+func (i *Interpreter) saveState() dynamicState {
+	// the stack is copied since whoever runs next appends to it
+	stack := append([]frame(nil), i.stack...)
+	return dynamicState{stack: stack, handlers: i.handlers, prompt: i.prompt}
+}
+
+func (i *Interpreter) restoreState(s dynamicState) {
+	i.stack, i.handlers, i.prompt = s.stack, s.handlers, s.prompt
+}
+
+// (call-with-prompt tag thunk handler) calls thunk, and if it calls
+// (abort-to-prompt tag value ...) returns what (handler k value ...) returns
+// instead. k is a procedure that carries on from abort-to-prompt, which
+// returns what k is called with, up to and including this prompt, and
+// returns what the prompt does. it can only be called once.
+func CallWithPromptPrim(args []Obj, e *Env) Obj {
+	if len(args) != 3 {
+		panic(MakeError(ArityErrorSym, "call-with-prompt takes 3 arguments"))
+	}
+	i := e.interp
+	i.collectAbandoned()
+
+	b := &body{resume: make(chan resumption), events: make(chan event)}
+	p := &prompt{tag: args[0], handler: args[2], outer: i.prompt, body: b}
+	state := i.saveState()
+	i.prompt = p
+	thunk := args[1]
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				// fills in the backtrace while the body's stack is there
+				i.conditionOf(r)
+				b.events <- event{panic: r}
+			}
+		}()
+		value := Call(thunk, nil, e)
+		b.events <- event{value: value}
+	}()
+	return i.await(p, state, e)
+}
+
+// (abort-to-prompt tag value ...) suspends everything up to the innermost
+// prompt with tag and calls its handler, see call-with-prompt
+func AbortToPromptPrim(args []Obj, e *Env) Obj {
+	if len(args) < 1 {
+		panic(MakeError(ArityErrorSym, "abort-to-prompt takes at least 1 argument"))
+	}
+	i := e.interp
+	for p := i.prompt; ; p = p.outer {
+		if p == nil {
+			panic(MakeError(ContinuationErrorSym, "no prompt with tag", args[0]))
+		}
+		if eqv(p.tag, args[0]) {
+			break
+		}
+	}
+	return i.suspend(&abort{tag: args[0], args: append([]Obj(nil), args[1:]...)})
+}
+
+// waits for the body of p to finish or abort. state is the waiting side's,
+// which is put back whenever the body stops running.
+func (i *Interpreter) await(p *prompt, state dynamicState, e *Env) Obj {
+	b := p.body
+	for {
+		ev := <-b.events
+		i.restoreState(state)
+		switch {
+		case ev.abort == nil && ev.panic != nil:
+			panic(ev.panic)
+		case ev.abort == nil:
+			return ev.value
+		case eqv(p.tag, ev.abort.tag):
+			k := i.continuation(p)
+			return Call(p.handler, append([]Obj{k}, ev.abort.args...), e)
+		}
+		// an abort to a prompt further out suspends this side too, and
+		// passes on what it's resumed with
+		value := i.suspendCancelling(ev.abort, b)
+		state = i.saveState()
+		i.prompt = p
+		b.resume <- resumption{value: value}
+	}
+}
+
+// suspends the body of the innermost prompt, which is the one running this
+// code, until it's resumed
+func (i *Interpreter) suspend(a *abort) Obj {
+	b := i.prompt.body
+	state := i.saveState()
+	b.events <- event{abort: a}
+	r := <-b.resume
+	if r.cancel {
+		panic(abandoned{})
+	}
+	i.restoreState(state)
+	return r.value
+}
+
+// like suspend, but cancels inner, which is suspended inside this body, if
+// this body is cancelled
+func (i *Interpreter) suspendCancelling(a *abort, inner *body) Obj {
+	defer func() {
+		if r := recover(); r != nil {
+			if _, ok := r.(abandoned); ok {
+				inner.cancel()
+			}
+			panic(r)
+		}
+	}()
+	return i.suspend(a)
+}
+
+// unwinds a suspended body and waits until it's done. the body's deferred
+// functions run, so the caller has to save and restore its state around it.
+func (b *body) cancel() {
+	b.resume <- resumption{cancel: true}
+	<-b.events
+}
+
+// ticket is what a continuation made by call-with-prompt holds on to, so that
+// the body can be cancelled when the continuation is garbage collected
+type ticket struct {
+	used bool
+}
+
+// makes the continuation of the suspended body of p
+func (i *Interpreter) continuation(p *prompt) Obj {
+	t := &ticket{}
+	b := p.body
+	runtime.SetFinalizer(t, func(t *ticket) {
+		if !t.used {
+			i.abandonedMu.Lock()
+			i.abandoned = append(i.abandoned, b)
+			i.abandonedMu.Unlock()
+		}
+	})
+	return MakeBuiltin(func(args []Obj, e *Env) Obj {
+		if len(args) > 1 {
+			panic(MakeError(ArityErrorSym, "continuation takes 0 or 1 arguments"))
+		}
+		if t.used {
+			panic(MakeError(ContinuationErrorSym, "continuation already resumed"))
+		}
+		t.used = true
+		value := Obj(Nil)
+		if len(args) == 1 {
+			value = args[0]
+		}
+
+		// the prompt is put back, around wherever the continuation is called
+		state := i.saveState()
+		p.outer = i.prompt
+		i.prompt = p
+		b.resume <- resumption{value: value}
+		return i.await(p, state, e)
+	})
+}
+
+// cancels the bodies whose continuations have been garbage collected, which
+// has to happen on the goroutine running Lisp code
+func (i *Interpreter) collectAbandoned() {
+	i.abandonedMu.Lock()
+	bodies := i.abandoned
+	i.abandoned = nil
+	i.abandonedMu.Unlock()
+	if len(bodies) == 0 {
+		return
+	}
+	state := i.saveState()
+	for _, b := range bodies {
+		b.cancel()
+	}
+	i.restoreState(state)
+}
+
+// whether code running now is inside b, which is nil for the top level
+func (i *Interpreter) inside(b *body) bool {
+	for p := i.prompt; p != nil; p = p.outer {
+		if p.body == b {
+			return true
+		}
+	}
+	return b == nil
+}
+
+// the body running now, nil at the top level
+func (i *Interpreter) currentBody() *body {
+	if i.prompt == nil {
+		return nil
+	}
+	return i.prompt.body
+}
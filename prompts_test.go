@@ -0,0 +1,37 @@
+package lisp
+
+import (
+	"runtime"
+	"testing"
+	"time"
+)
+
+// a body whose continuation is dropped shouldn't keep its goroutine around
+func TestAbandonedBodiesAreCancelled(t *testing.T) {
+	for _, engine := range []Engine{VMEngine, TreeEngine} {
+		i := New(WithEngine(engine))
+		before := runtime.NumGoroutine()
+		for n := 0; n < 50; n++ {
+			_, err := i.EvalString(`
+(call-with-prompt 'p (lambda () (abort-to-prompt 'p)) (lambda (k) 'dropped))
+(call-with-prompt 'p
+  (lambda () (call-with-prompt 'q (lambda () (abort-to-prompt 'p)) (lambda (k) 'unused)))
+  (lambda (k) 'dropped))`)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		// finalizers run after a collection, and the bodies are cancelled by
+		// the next call-with-prompt
+		for tries := 0; tries < 50 && runtime.NumGoroutine() > before; tries++ {
+			runtime.GC()
+			time.Sleep(time.Millisecond)
+			if _, err := i.EvalString(`(call-with-prompt 'p (lambda () 1) (lambda (k) 1))`); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if n := runtime.NumGoroutine(); n > before {
+			t.Errorf("engine %v: %v goroutines left over", engine, n-before)
+		}
+	}
+}
@@ -0,0 +1,264 @@
+package lisp
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// checks that o is a string, for primitives that only take strings
+func stringArg(name string, o Obj) string {
+	s, ok := o.(*String)
+	if !ok {
+		panic(MakeError(TypeErrorSym, fmt.Sprintf("%v takes string arguments", name), o))
+	}
+	return s.s
+}
+
+// checks that o is a number that fits in an int, for indices and lengths
+func intArg(name string, o Obj) int {
+	n, ok := o.(*Number)
+	if !ok {
+		panic(MakeError(TypeErrorSym, fmt.Sprintf("%v takes number arguments", name), o))
+	}
+	i, ok := n.Int64()
+	if !ok || i != int64(int(i)) {
+		panic(MakeError(RangeErrorSym, fmt.Sprintf("%v index out of range", name), o))
+	}
+	return int(i)
+}
+
+func IsStringPrim(args []Obj, e *Env) Obj {
+	if len(args) != 1 {
+		panic(MakeError(ArityErrorSym, "string? takes 1 argument"))
+	}
+	_, ok := args[0].(*String)
+	return boolToLisp(ok)
+}
+
+func StringLengthPrim(args []Obj, e *Env) Obj {
+	if len(args) != 1 {
+		panic(MakeError(ArityErrorSym, "string-length takes 1 argument"))
+	}
+	s := stringArg("string-length", args[0])
+	return MakeInt(int64(utf8.RuneCountInString(s)))
+}
+
+// (substring s start [end]), indices count characters not bytes
+func SubstringPrim(args []Obj, e *Env) Obj {
+	if len(args) != 2 && len(args) != 3 {
+		panic(MakeError(ArityErrorSym, "substring takes 2 or 3 arguments"))
+	}
+	runes := []rune(stringArg("substring", args[0]))
+	start := intArg("substring", args[1])
+	end := len(runes)
+	if len(args) == 3 {
+		end = intArg("substring", args[2])
+	}
+	if start < 0 || end > len(runes) || start > end {
+		panic(MakeError(RangeErrorSym, "substring index out of range", args[1:]...))
+	}
+	return MakeString(string(runes[start:end]))
+}
+
+func StringAppendPrim(args []Obj, e *Env) Obj {
+	b := strings.Builder{}
+	for _, arg := range args {
+		b.WriteString(stringArg("string-append", arg))
+	}
+	return MakeString(b.String())
+}
+
+func StringToSymbolPrim(args []Obj, e *Env) Obj {
+	if len(args) != 1 {
+		panic(MakeError(ArityErrorSym, "string->symbol takes 1 argument"))
+	}
+	return e.interp.Intern(stringArg("string->symbol", args[0]))
+}
+
+func SymbolToStringPrim(args []Obj, e *Env) Obj {
+	if len(args) != 1 {
+		panic(MakeError(ArityErrorSym, "symbol->string takes 1 argument"))
+	}
+	sym, ok := args[0].(*Symbol)
+	if !ok {
+		panic(MakeError(TypeErrorSym, "symbol->string takes a symbol", args[0]))
+	}
+	return MakeString(sym.String())
+}
+
+func NumberToStringPrim(args []Obj, e *Env) Obj {
+	if len(args) != 1 && len(args) != 2 {
+		panic(MakeError(ArityErrorSym, "number->string takes 1 or 2 arguments"))
+	}
+	n, ok := args[0].(*Number)
+	if !ok {
+		panic(MakeError(TypeErrorSym, "number->string takes a number", args[0]))
+	}
+	return MakeString(formatNum(n, radixArg("number->string", args)))
+}
+
+// returns #f if the string isn't a number
+func StringToNumberPrim(args []Obj, e *Env) Obj {
+	if len(args) != 1 && len(args) != 2 {
+		panic(MakeError(ArityErrorSym, "string->number takes 1 or 2 arguments"))
+	}
+	n, ok := parseNumRadix(stringArg("string->number", args[0]), radixArg("string->number", args))
+	if !ok {
+		return False
+	}
+	return n
+}
+
+// compares each adjacent pair of strings with cmp
+func compareStrings(name string, args []Obj, cmp func(a, b string) bool) Obj {
+	if len(args) < 1 {
+		panic(MakeError(ArityErrorSym, fmt.Sprintf("%v takes at least 1 argument", name)))
+	}
+	prev := stringArg(name, args[0])
+	for _, arg := range args[1:] {
+		curr := stringArg(name, arg)
+		if !cmp(prev, curr) {
+			return False
+		}
+		prev = curr
+	}
+	return True
+}
+
+func StringEqualPrim(args []Obj, e *Env) Obj {
+	return compareStrings("string=?", args, func(a, b string) bool { return a == b })
+}
+
+func StringLessPrim(args []Obj, e *Env) Obj {
+	return compareStrings("string<?", args, func(a, b string) bool { return a < b })
+}
+
+// (string-ref s k), k counts characters not bytes
+func StringRefPrim(args []Obj, e *Env) Obj {
+	if len(args) != 2 {
+		panic(MakeError(ArityErrorSym, "string-ref takes 2 arguments"))
+	}
+	runes := []rune(stringArg("string-ref", args[0]))
+	k := intArg("string-ref", args[1])
+	if k < 0 || k >= len(runes) {
+		panic(MakeError(RangeErrorSym, "string-ref index out of range", args[1]))
+	}
+	return MakeChar(runes[k])
+}
+
+// (string char ...) makes a string out of its characters
+func StringPrim(args []Obj, e *Env) Obj {
+	return MakeString(charsToString("string", args))
+}
+
+func StringToListPrim(args []Obj, e *Env) Obj {
+	if len(args) != 1 {
+		panic(MakeError(ArityErrorSym, "string->list takes 1 argument"))
+	}
+	out := []Obj{}
+	for _, r := range stringArg("string->list", args[0]) {
+		out = append(out, MakeChar(r))
+	}
+	e.interp.allocate(len(out))
+	return sliceToList(out)
+}
+
+func ListToStringPrim(args []Obj, e *Env) Obj {
+	if len(args) != 1 {
+		panic(MakeError(ArityErrorSym, "list->string takes 1 argument"))
+	}
+	return MakeString(charsToString("list->string", listToSlice(args[0])))
+}
+
+func charsToString(name string, chars []Obj) string {
+	b := strings.Builder{}
+	for _, c := range chars {
+		b.WriteRune(charArg(name, c))
+	}
+	return b.String()
+}
+
+// (string-index s needle) returns the character index of the first
+// occurrence of needle in s, or #f if there isn't one. needle is a string
+// or a character.
+func StringIndexPrim(args []Obj, e *Env) Obj {
+	if len(args) != 2 {
+		panic(MakeError(ArityErrorSym, "string-index takes 2 arguments"))
+	}
+	s := stringArg("string-index", args[0])
+	var needle string
+	if c, ok := args[1].(*Char); ok {
+		needle = string(c.r)
+	} else {
+		needle = stringArg("string-index", args[1])
+	}
+	i := strings.Index(s, needle)
+	if i < 0 {
+		return False
+	}
+	return MakeInt(int64(utf8.RuneCountInString(s[:i])))
+}
+
+// (string-split s [separator]) splits on whitespace if there's no separator
+func StringSplitPrim(args []Obj, e *Env) Obj {
+	if len(args) != 1 && len(args) != 2 {
+		panic(MakeError(ArityErrorSym, "string-split takes 1 or 2 arguments"))
+	}
+	s := stringArg("string-split", args[0])
+	var parts []string
+	if len(args) == 1 {
+		parts = strings.Fields(s)
+	} else {
+		parts = strings.Split(s, stringArg("string-split", args[1]))
+	}
+	out := make([]Obj, len(parts))
+	for i, part := range parts {
+		out[i] = MakeString(part)
+	}
+	e.interp.allocate(len(out))
+	return sliceToList(out)
+}
+
+// (string-join list [separator]) joins with a space if there's no separator
+func StringJoinPrim(args []Obj, e *Env) Obj {
+	if len(args) != 1 && len(args) != 2 {
+		panic(MakeError(ArityErrorSym, "string-join takes 1 or 2 arguments"))
+	}
+	sep := " "
+	if len(args) == 2 {
+		sep = stringArg("string-join", args[1])
+	}
+	parts := []string{}
+	for _, part := range listToSlice(args[0]) {
+		parts = append(parts, stringArg("string-join", part))
+	}
+	return MakeString(strings.Join(parts, sep))
+}
+
+// prints without quotes or a newline, unlike print
+func DisplayPrim(args []Obj, e *Env) Obj {
+	if len(args) != 1 {
+		panic(MakeError(ArityErrorSym, "display takes 1 argument"))
+	}
+	fmt.Fprint(e.interp.out, displayString(args[0]))
+	return Nil
+}
+
+// prints so that what's printed can be read back in, like print without the
+// newline
+func WritePrim(args []Obj, e *Env) Obj {
+	if len(args) != 1 {
+		panic(MakeError(ArityErrorSym, "write takes 1 argument"))
+	}
+	fmt.Fprint(e.interp.out, mustStringer(args[0]).String())
+	return Nil
+}
+
+func NewlinePrim(args []Obj, e *Env) Obj {
+	if len(args) != 0 {
+		panic(MakeError(ArityErrorSym, "newline takes no args"))
+	}
+	fmt.Fprintln(e.interp.out)
+	return Nil
+}
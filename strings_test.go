@@ -0,0 +1,49 @@
+package lisp
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestStrings(t *testing.T) {
+	tests := []struct {
+		src, want string
+	}{
+		{`(list "a\tb\n" "q\"uote\\" "\x41;bc" "λ")`, `("a\tb\n" "q\"uote\\" "Abc" "λ")`},
+		{`(list (string-length "héllo") (substring "hello" 1 3) (substring "hello" 2) (string-append "a" "" "bc"))`, `(5 "el" "llo" "abc")`},
+		{`(list (string->symbol "foo") (symbol->string 'bar) (number->string 1/2) (string->number "42") (string->number "nope"))`, `(foo "bar" "1/2" 42 #f)`},
+		{`(list (string=? "a" "a" "a") (string=? "a" "b") (string<? "a" "b" "c") (string<? "b" "a"))`, `(#t #f #t #f)`},
+		{`(list (string-index "hello" "l") (string-index "hello" "z") (string-split "a,b,,c" ",") (string-join '("a" "b" "c") ", "))`, `(2 #f ("a" "b" "" "c") "a, b, c")`},
+		{`(list (string-ref "héllo" 1) (string->list "ab") (list->string (list #\a #\λ)) (string #\x #\y) (string) (string-index "hello" #\l))`, `(#\é (#\a #\b) "aλ" "xy" "" 2)`},
+		{`(list (apply string (string->list "round")) (list->string (cdr (string->list "trip"))))`, `("round" "rip")`},
+		{`(substring "abc" 2 5)`, "error range-error"},
+		{`(string-ref "abc" 3)`, "error range-error"},
+		{`(list->string (list #\a "b"))`, "error type-error"},
+		{`(string-length 'a)`, "error type-error"},
+		{`"bad \q escape"`, "error read-error"},
+	}
+	for _, test := range tests {
+		result, err := New().EvalString(test.src)
+		got := fmt.Sprint(result)
+		if lispErr, ok := err.(*Error); ok {
+			got = "error " + lispErr.Kind.String()
+		} else if err != nil {
+			got = err.Error()
+		}
+		if got != test.want {
+			t.Errorf("%v: got %v, want %v", test.src, got, test.want)
+		}
+	}
+}
+
+// display writes strings as they are, write as they're read
+func TestDisplayAndWriteStrings(t *testing.T) {
+	out := &bytes.Buffer{}
+	if _, err := New(WithOutput(out)).EvalString(`(display "a \"b\"\n") (write "a \"b\"\n")`); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := out.String(), "a \"b\"\n\"a \\\"b\\\"\\n\""; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
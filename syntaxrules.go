@@ -0,0 +1,405 @@
+package lisp
+
+/*
+Macros made by syntax-rules are hygienic. The symbols a template puts into
+an expansion, as opposed to the ones that came from the macro's arguments,
+are renamed each time the macro is used. A renamed symbol that the expansion
+binds, like the temporary in or, can't capture the variables of the code the
+macro was used in, and one it doesn't bind refers to whatever the symbol
+meant where the macro was defined, see Env.lookup. Symbols in the quoted
+parts of a template are left alone, so quoting gives the symbols the
+template was written with.
+*/
+
+// alias is where a renamed symbol came from
+type alias struct {
+	sym *Symbol
+	env *Env // where the macro that renamed it was defined
+}
+
+// the symbol s was renamed from, going back through all of its renames
+func (s *Symbol) unaliased() *Symbol {
+	for s.alias != nil {
+		s = s.alias.sym
+	}
+	return s
+}
+
+// whether o is sym, or sym renamed
+func sameName(o Obj, sym *Symbol) bool {
+	s, ok := o.(*Symbol)
+	return ok && sym != nil && *s.unaliased() == *sym.unaliased()
+}
+
+// whether o is else, which it still is after a macro renames it
+func isElse(o Obj) bool {
+	return sameName(o, Else)
+}
+
+type syntaxRules struct {
+	ellipsis *Symbol
+	literals []*Symbol
+	rules    []syntaxRule
+}
+
+type syntaxRule struct {
+	pattern  Obj // without the keyword at the start, which is ignored
+	template Obj
+}
+
+// (syntax-rules [ellipsis] (literal ...) (pattern template) ...) makes a
+// macro, which define-syntax and let-syntax give a name
+func SyntaxRulesPrim(o Obj, e *Env) Obj {
+	args := listToSlice(o)
+	r := &syntaxRules{ellipsis: Ellipsis}
+	if len(args) > 0 {
+		if sym, ok := args[0].(*Symbol); ok {
+			r.ellipsis = sym
+			args = args[1:]
+		}
+	}
+	if len(args) < 1 {
+		panic(MakeError(SyntaxErrorSym, "syntax-rules takes a list of literals and then rules"))
+	}
+	for _, literal := range listToSlice(args[0]) {
+		sym, ok := literal.(*Symbol)
+		if !ok {
+			panic(MakeError(SyntaxErrorSym, "syntax-rules literals must be symbols", literal))
+		}
+		r.literals = append(r.literals, sym)
+	}
+	for _, rule := range args[1:] {
+		parts := listToSlice(rule)
+		if len(parts) != 2 {
+			panic(MakeError(SyntaxErrorSym, "syntax-rules rules are (pattern template)", rule))
+		}
+		pattern, ok := parts[0].(*Pair)
+		if !ok {
+			panic(MakeError(SyntaxErrorSym, "syntax-rules patterns must be lists", parts[0]))
+		}
+		r.rules = append(r.rules, syntaxRule{pattern: pattern.Cdr, template: parts[1]})
+	}
+	return &Macro{scope: e, rules: r}
+}
+
+// (define-syntax name transformer)
+func DefineSyntaxPrim(o Obj, e *Env) Obj {
+	args := listToSlice(o)
+	if len(args) != 2 {
+		panic(MakeError(SyntaxErrorSym, "define-syntax takes 2 arguments"))
+	}
+	name, ok := args[0].(*Symbol)
+	if !ok {
+		panic(MakeError(SyntaxErrorSym, "name must be a symbol", args[0]))
+	}
+	return e.Bind(name, namedMacro(name, Eval(args[1], e)))
+}
+
+// (let-syntax ((name transformer) ...) body ...), where the transformers
+// are evaluated outside of the let-syntax
+func LetSyntaxPrim(o Obj, e *Env) Obj {
+	return letSyntax("let-syntax", o, e, false)
+}
+
+// like let-syntax, but the transformers can refer to each other
+func LetrecSyntaxPrim(o Obj, e *Env) Obj {
+	return letSyntax("letrec-syntax", o, e, true)
+}
+
+func letSyntax(name string, o Obj, e *Env, recursive bool) Obj {
+	args := listToSlice(o)
+	if len(args) < 1 {
+		panic(MakeError(SyntaxErrorSym, name+" takes bindings and a body"))
+	}
+	scope := MakeEnv(e)
+	outer := e
+	if recursive {
+		outer = scope
+	}
+	for _, binding := range listToSlice(args[0]) {
+		parts := listToSlice(binding)
+		var sym *Symbol
+		if len(parts) == 2 {
+			sym, _ = parts[0].(*Symbol)
+		}
+		if sym == nil {
+			panic(MakeError(SyntaxErrorSym, name+" bindings are (name transformer)", binding))
+		}
+		scope.Bind(sym, namedMacro(sym, Eval(parts[1], outer)))
+	}
+	body := args[1:]
+	if len(body) == 0 {
+		return Nil
+	}
+	for _, expr := range body[:len(body)-1] {
+		Eval(expr, scope)
+	}
+	return MakeTailCall(body[len(body)-1], scope)
+}
+
+// checks that o, the value of a transformer, is a macro, and names it if
+// it's anonymous
+func namedMacro(name *Symbol, o Obj) *Macro {
+	m, ok := o.(*Macro)
+	if !ok {
+		panic(MakeError(SyntaxErrorSym, "syntax definitions take a macro, like one made by syntax-rules", o))
+	}
+	if m.name == nil {
+		m.name = name
+	}
+	return m
+}
+
+// expands a use of a syntax-rules macro with the first rule that matches its
+// arguments
+func (m *Macro) expandRules(args Obj) Obj {
+	for _, rule := range m.rules.rules {
+		vars := patternVars{}
+		if m.rules.match(rule.pattern, args, vars) {
+			x := &expansion{rules: m.rules, ellipsis: m.rules.ellipsis, env: m.scope, renames: map[Symbol]*Symbol{}}
+			return x.instantiate(rule.template, vars, 0)
+		}
+	}
+	name := m.name
+	if name == nil {
+		name = Underscore
+	}
+	panic(MakeError(SyntaxErrorSym, "no syntax-rules pattern matches", Cons(name, args)))
+}
+
+// the value of a pattern variable: one object, or one for each repetition if
+// the variable was followed by an ellipsis
+type patternVar struct {
+	value Obj
+	items []*patternVar // nil unless the variable repeats
+}
+
+type patternVars map[Symbol]*patternVar
+
+func (r *syntaxRules) isLiteral(sym *Symbol) bool {
+	for _, literal := range r.literals {
+		if *literal == *sym {
+			return true
+		}
+	}
+	return false
+}
+
+// matches o against pattern, adding the pattern variables to vars
+func (r *syntaxRules) match(pattern, o Obj, vars patternVars) bool {
+	switch p := pattern.(type) {
+	case *Symbol:
+		if r.isLiteral(p) {
+			return sameName(o, p)
+		}
+		if !sameName(p, Underscore) {
+			vars[*p] = &patternVar{value: o}
+		}
+		return true
+	case *Pair:
+		if next, ok := p.Cdr.(*Pair); ok && sameName(next.Car, r.ellipsis) {
+			return r.matchRepeated(p.Car, next.Cdr, o, vars)
+		}
+		pair, ok := o.(*Pair)
+		return ok && r.match(p.Car, pair.Car, vars) && r.match(p.Cdr, pair.Cdr, vars)
+	case *Vector:
+		v, ok := o.(*Vector)
+		return ok && r.match(sliceToList(p.items), sliceToList(v.items), vars)
+	}
+	return equal(pattern, o)
+}
+
+// matches sub against as many elements of o as it can while leaving enough
+// for the patterns in rest, which come after the ellipsis
+func (r *syntaxRules) matchRepeated(sub, rest, o Obj, vars patternVars) bool {
+	items, _ := improperListToSlice(o)
+	after, _ := improperListToSlice(rest)
+	n := len(items) - len(after)
+	if n < 0 {
+		return false
+	}
+	repeats := make([]patternVars, n)
+	for k := range repeats {
+		repeats[k] = patternVars{}
+		pair := o.(*Pair)
+		if !r.match(sub, pair.Car, repeats[k]) {
+			return false
+		}
+		o = pair.Cdr
+	}
+	for _, sym := range r.patternVarsOf(sub, nil) {
+		v := &patternVar{items: make([]*patternVar, n)}
+		for k := range repeats {
+			v.items[k] = repeats[k][sym]
+		}
+		vars[sym] = v
+	}
+	return r.match(rest, o, vars)
+}
+
+// the pattern variables in pattern, appended to vars
+func (r *syntaxRules) patternVarsOf(pattern Obj, vars []Symbol) []Symbol {
+	switch p := pattern.(type) {
+	case *Symbol:
+		if !r.isLiteral(p) && !sameName(p, Underscore) && !sameName(p, r.ellipsis) {
+			vars = append(vars, *p)
+		}
+	case *Pair:
+		vars = r.patternVarsOf(p.Cdr, r.patternVarsOf(p.Car, vars))
+	case *Vector:
+		for _, item := range p.items {
+			vars = r.patternVarsOf(item, vars)
+		}
+	}
+	return vars
+}
+
+// symbolsOf appends the symbols in a template to syms
+func symbolsOf(template Obj, syms []Symbol) []Symbol {
+	switch t := template.(type) {
+	case *Symbol:
+		syms = append(syms, *t)
+	case *Pair:
+		syms = symbolsOf(t.Cdr, symbolsOf(t.Car, syms))
+	case *Vector:
+		for _, item := range t.items {
+			syms = symbolsOf(item, syms)
+		}
+	}
+	return syms
+}
+
+// expansion is one use of a macro, with the symbols it has renamed so far,
+// so that a symbol is renamed the same way everywhere in the expansion
+type expansion struct {
+	rules    *syntaxRules
+	ellipsis *Symbol // nil inside (... template), where ellipses aren't special
+	env      *Env
+	renames  map[Symbol]*Symbol
+}
+
+func (x *expansion) rename(sym *Symbol) *Symbol {
+	if renamed, ok := x.renames[*sym]; ok {
+		return renamed
+	}
+	renamed := &Symbol{s: sym.s, alias: &alias{sym: sym, env: x.env}}
+	x.renames[*sym] = renamed
+	return renamed
+}
+
+// fills in template with vars. quoted is 0 for code, -1 inside a quote,
+// and how many quasiquotes deep it is otherwise.
+func (x *expansion) instantiate(template Obj, vars patternVars, quoted int) Obj {
+	switch t := template.(type) {
+	case *Symbol:
+		if v, ok := vars[*t]; ok {
+			if v.items != nil {
+				panic(MakeError(SyntaxErrorSym, "pattern variable used without an ellipsis", t))
+			}
+			return v.value
+		}
+		if quoted != 0 {
+			return t
+		}
+		return x.rename(t)
+	case *Pair:
+		return x.form(t, vars, quoted)
+	case *Vector:
+		return MakeVector(listToSlice(x.list(sliceToList(t.items), vars, quoted)))
+	}
+	return template
+}
+
+// fills in a template that's a list, keeping track of quoting
+func (x *expansion) form(t *Pair, vars patternVars, quoted int) Obj {
+	head, ok := t.Car.(*Symbol)
+	if !ok {
+		return x.list(t, vars, quoted)
+	}
+	if rest, ok := t.Cdr.(*Pair); ok && sameName(head, x.ellipsis) {
+		// (... template) is template with ellipses taken literally
+		ellipsis := x.ellipsis
+		x.ellipsis = nil
+		defer func() { x.ellipsis = ellipsis }()
+		return x.instantiate(rest.Car, vars, quoted)
+	}
+	inner := quoted
+	switch *head.unaliased() {
+	case *QuoteSym:
+		if quoted == 0 {
+			inner = -1
+		}
+	case *QuasiquoteSym:
+		if quoted >= 0 {
+			inner = quoted + 1
+		}
+	case *UnquoteSym, *UnquoteSplicingSym:
+		if quoted > 0 {
+			inner = quoted - 1
+		}
+	default:
+		return x.list(t, vars, quoted)
+	}
+	// the quoting keywords themselves aren't renamed
+	return Cons(head, x.list(t.Cdr, vars, inner))
+}
+
+// fills in the elements of a list, repeating the ones followed by ellipses
+func (x *expansion) list(t Obj, vars patternVars, quoted int) Obj {
+	pair, ok := t.(*Pair)
+	if !ok {
+		return x.instantiate(t, vars, quoted)
+	}
+	depth := 0
+	rest := pair.Cdr
+	for next, ok := rest.(*Pair); ok && sameName(next.Car, x.ellipsis); next, ok = rest.(*Pair) {
+		depth++
+		rest = next.Cdr
+	}
+	if depth == 0 {
+		return Cons(x.instantiate(pair.Car, vars, quoted), x.list(pair.Cdr, vars, quoted))
+	}
+	items := x.repeat(pair.Car, vars, quoted, depth)
+	tail := x.list(rest, vars, quoted)
+	for k := len(items) - 1; k >= 0; k-- {
+		tail = Cons(items[k], tail)
+	}
+	return tail
+}
+
+// fills in sub once for each repetition of the pattern variables in it,
+// flattening depth levels of repetitions
+func (x *expansion) repeat(sub Obj, vars patternVars, quoted, depth int) []Obj {
+	repeating := []Symbol{}
+	n := 0
+	for _, sym := range symbolsOf(sub, nil) {
+		v, ok := vars[sym]
+		if !ok || v.items == nil {
+			continue
+		}
+		if len(repeating) > 0 && len(v.items) != n {
+			panic(MakeError(SyntaxErrorSym, "pattern variables under the same ellipsis matched different numbers of times", &sym))
+		}
+		n = len(v.items)
+		repeating = append(repeating, sym)
+	}
+	if len(repeating) == 0 {
+		panic(MakeError(SyntaxErrorSym, "ellipsis after a template with no repeating pattern variables", sub))
+	}
+	items := []Obj{}
+	for k := 0; k < n; k++ {
+		inner := make(patternVars, len(vars))
+		for sym, v := range vars {
+			inner[sym] = v
+		}
+		for _, sym := range repeating {
+			inner[sym] = vars[sym].items[k]
+		}
+		if depth > 1 {
+			items = append(items, x.repeat(sub, inner, quoted, depth-1)...)
+		} else {
+			items = append(items, x.instantiate(sub, inner, quoted))
+		}
+	}
+	return items
+}
@@ -0,0 +1,314 @@
+package lisp
+
+import (
+	"fmt"
+	"reflect"
+	"runtime"
+)
+
+// Only one goroutine runs Lisp code at a time, the one holding the
+// interpreter's lock, so environments and the interpreter's own state don't
+// need locks of their own. Threads let go of it while they wait on a
+// channel, a mutex or another thread, and every so often while they run,
+// see tick. Threads share the budget of the call from Go that spawned them,
+// see Limits.
+
+// how many applications a thread makes before letting the others run
+const yieldEvery = 1 << 12
+
+// what a goroutine needs to carry on once it has the lock back
+type threadState struct {
+	dynamicState
+	thread *Thread
+	budget *budget
+}
+
+// takes the lock for a call from Go, which starts with a fresh state
+func (i *Interpreter) enter(b *budget) {
+	i.lock.Lock()
+	i.restoreState(dynamicState{})
+	i.thread = nil
+	i.budget = b
+}
+
+// lets other threads run, returning what relock needs to carry on
+func (i *Interpreter) unlock() threadState {
+	s := threadState{dynamicState: i.saveState(), thread: i.thread, budget: i.budget}
+	i.lock.Unlock()
+	return s
+}
+
+func (i *Interpreter) relock(s threadState) {
+	i.lock.Lock()
+	i.restoreState(s.dynamicState)
+	i.thread = s.thread
+	i.budget = s.budget
+}
+
+// runs f, which may block, without the lock. f should stop waiting when
+// stop is closed, which happens when the time limit is up or the call from
+// Go is cancelled.
+func (i *Interpreter) blocking(f func(stop <-chan struct{})) {
+	stop := i.budget.done
+	func() {
+		s := i.unlock()
+		defer i.relock(s)
+		f(stop)
+	}()
+	i.checkStopped()
+}
+
+// tick is called on every application. It lets other threads run now and
+// then so that one that never waits can't hold up the rest, and counts the
+// step against the budget.
+func (i *Interpreter) tick() {
+	i.ticks++
+	if i.ticks%yieldEvery == 0 && i.threads > 0 {
+		i.blocking(func(<-chan struct{}) { runtime.Gosched() })
+	}
+	i.step()
+}
+
+func (*Thread) String() string {
+	return "#<thread>"
+}
+
+func (*Channel) String() string {
+	return "#<channel>"
+}
+
+func (*Mutex) String() string {
+	return "#<mutex>"
+}
+
+var (
+	_ fmt.Stringer = &Thread{}
+	_ fmt.Stringer = &Channel{}
+	_ fmt.Stringer = &Mutex{}
+)
+
+// (spawn thunk) calls thunk on a thread of its own and returns the thread
+func SpawnPrim(args []Obj, e *Env) Obj {
+	if len(args) != 1 {
+		panic(MakeError(ArityErrorSym, "spawn takes 1 argument"))
+	}
+	i := e.interp
+	t := &Thread{done: make(chan struct{})}
+	thunk := args[0]
+	b := i.budget
+	b.acquire()
+	i.threads++
+	go func() {
+		i.enter(b)
+		i.thread = t
+		defer b.release()
+		defer func() {
+			if r := recover(); r != nil {
+				// fills in the backtrace while the thread's stack is there
+				i.conditionOf(r)
+				t.panic = r
+			}
+			i.threads--
+			i.lock.Unlock()
+			close(t.done)
+		}()
+		t.result = Call(thunk, nil, e)
+	}()
+	return t
+}
+
+func threadArg(name string, o Obj) *Thread {
+	t, ok := o.(*Thread)
+	if !ok {
+		panic(MakeError(TypeErrorSym, fmt.Sprintf("%v takes a thread", name), o))
+	}
+	return t
+}
+
+// (join thread) waits for thread to finish and returns what its thunk
+// returned, or raises what it raised
+func JoinPrim(args []Obj, e *Env) Obj {
+	if len(args) != 1 {
+		panic(MakeError(ArityErrorSym, "join takes 1 argument"))
+	}
+	t := threadArg("join", args[0])
+	e.interp.blocking(func(stop <-chan struct{}) {
+		select {
+		case <-t.done:
+		case <-stop:
+		}
+	})
+	if t.panic != nil {
+		panic(t.panic)
+	}
+	return t.result
+}
+
+func IsThreadPrim(args []Obj, e *Env) Obj {
+	if len(args) != 1 {
+		panic(MakeError(ArityErrorSym, "thread? takes 1 argument"))
+	}
+	_, ok := args[0].(*Thread)
+	return boolToLisp(ok)
+}
+
+func channelArg(name string, o Obj) *Channel {
+	c, ok := o.(*Channel)
+	if !ok {
+		panic(MakeError(TypeErrorSym, fmt.Sprintf("%v takes a channel", name), o))
+	}
+	return c
+}
+
+// (make-channel [capacity]) makes a channel that holds up to capacity
+// values before sends wait, 0 by default
+func MakeChannelPrim(args []Obj, e *Env) Obj {
+	if len(args) > 1 {
+		panic(MakeError(ArityErrorSym, "make-channel takes 0 or 1 arguments"))
+	}
+	capacity := 0
+	if len(args) == 1 {
+		capacity = intArg("make-channel", args[0])
+		if capacity < 0 {
+			panic(MakeError(RangeErrorSym, "make-channel takes a capacity of at least 0", args[0]))
+		}
+	}
+	return &Channel{ch: make(chan Obj, capacity)}
+}
+
+func IsChannelPrim(args []Obj, e *Env) Obj {
+	if len(args) != 1 {
+		panic(MakeError(ArityErrorSym, "channel? takes 1 argument"))
+	}
+	_, ok := args[0].(*Channel)
+	return boolToLisp(ok)
+}
+
+// (channel-send channel value) waits until there's room for value and
+// returns it
+func ChannelSendPrim(args []Obj, e *Env) Obj {
+	if len(args) != 2 {
+		panic(MakeError(ArityErrorSym, "channel-send takes 2 arguments"))
+	}
+	c := channelArg("channel-send", args[0])
+	e.interp.blocking(func(stop <-chan struct{}) {
+		select {
+		case c.ch <- args[1]:
+		case <-stop:
+		}
+	})
+	return args[1]
+}
+
+// (channel-receive channel) waits for a value and returns it
+func ChannelReceivePrim(args []Obj, e *Env) Obj {
+	if len(args) != 1 {
+		panic(MakeError(ArityErrorSym, "channel-receive takes 1 argument"))
+	}
+	c := channelArg("channel-receive", args[0])
+	var value Obj
+	e.interp.blocking(func(stop <-chan struct{}) {
+		select {
+		case value = <-c.ch:
+		case <-stop:
+		}
+	})
+	return value
+}
+
+// (channel-select op ...) waits until one of the ops can go ahead, does it,
+// and returns a pair of its channel and the value received or sent. an op is
+// a channel to receive from, or a list (channel value) to send value on.
+func ChannelSelectPrim(args []Obj, e *Env) Obj {
+	if len(args) < 1 {
+		panic(MakeError(ArityErrorSym, "channel-select takes at least 1 argument"))
+	}
+	cases := make([]reflect.SelectCase, len(args))
+	ops := make([]struct {
+		channel *Channel
+		value   Obj
+	}, len(args))
+	for n, arg := range args {
+		if c, ok := arg.(*Channel); ok {
+			ops[n].channel = c
+			cases[n] = reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(c.ch)}
+			continue
+		}
+		send, ok := arg.(*Pair)
+		if !ok || len(listToSlice(send)) != 2 {
+			panic(MakeError(TypeErrorSym, "channel-select takes channels or (channel value) lists", arg))
+		}
+		ops[n].channel = channelArg("channel-select", send.Car)
+		ops[n].value = send.Cdr.(*Pair).Car
+		cases[n] = reflect.SelectCase{
+			Dir:  reflect.SelectSend,
+			Chan: reflect.ValueOf(ops[n].channel.ch),
+			Send: reflect.ValueOf(ops[n].value),
+		}
+	}
+
+	var chosen int
+	var received reflect.Value
+	e.interp.blocking(func(stop <-chan struct{}) {
+		// a nil stop is left out of the select by being the zero Value
+		stopCase := reflect.SelectCase{Dir: reflect.SelectRecv}
+		if stop != nil {
+			stopCase.Chan = reflect.ValueOf(stop)
+		}
+		chosen, received, _ = reflect.Select(append(cases, stopCase))
+	})
+	value := ops[chosen].value
+	if cases[chosen].Dir == reflect.SelectRecv {
+		value = received.Interface().(Obj)
+	}
+	return Cons(ops[chosen].channel, value)
+}
+
+func mutexArg(name string, args []Obj) *Mutex {
+	if len(args) != 1 {
+		panic(MakeError(ArityErrorSym, name+" takes 1 argument"))
+	}
+	m, ok := args[0].(*Mutex)
+	if !ok {
+		panic(MakeError(TypeErrorSym, fmt.Sprintf("%v takes a mutex", name), args[0]))
+	}
+	return m
+}
+
+func MakeMutexPrim(args []Obj, e *Env) Obj {
+	if len(args) != 0 {
+		panic(MakeError(ArityErrorSym, "make-mutex takes no arguments"))
+	}
+	return &Mutex{ch: make(chan struct{}, 1)}
+}
+
+func IsMutexPrim(args []Obj, e *Env) Obj {
+	if len(args) != 1 {
+		panic(MakeError(ArityErrorSym, "mutex? takes 1 argument"))
+	}
+	_, ok := args[0].(*Mutex)
+	return boolToLisp(ok)
+}
+
+// (mutex-lock! mutex) waits until no other thread holds mutex and takes it.
+// mutexes aren't reentrant, so a thread locking one it holds waits forever.
+func MutexLockPrim(args []Obj, e *Env) Obj {
+	m := mutexArg("mutex-lock!", args)
+	e.interp.blocking(func(stop <-chan struct{}) {
+		select {
+		case m.ch <- struct{}{}:
+		case <-stop:
+		}
+	})
+	return Nil
+}
+
+func MutexUnlockPrim(args []Obj, e *Env) Obj {
+	m := mutexArg("mutex-unlock!", args)
+	select {
+	case <-m.ch:
+	default:
+		panic(MakeError(ErrorSym, "mutex-unlock! on a mutex that isn't locked", m))
+	}
+	return Nil
+}
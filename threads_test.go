@@ -0,0 +1,45 @@
+package lisp
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+// Go code can use one interpreter from several goroutines while Lisp
+// threads are running in it too. run with -race to check it properly.
+func TestConcurrentUse(t *testing.T) {
+	i := New()
+	i.RegisterFunc("go-eval", func(args []Obj) (Obj, error) {
+		return i.Eval(args[0])
+	})
+	_, err := i.EvalString(`
+(define spin (lambda (n acc) (if (= n 0) acc (spin (- n 1) (+ acc 1)))))
+(define spinner (spawn (lambda () (spin 20000 0))))`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wg := sync.WaitGroup{}
+	for n := 0; n < 8; n++ {
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+			name := fmt.Sprintf("var-%v", n)
+			i.Define(name, MakeInt(int64(n)))
+			src := fmt.Sprintf(`(list (string->symbol "sym-%v") (go-eval '(+ %v 1)) (spin 1000 0))`, n, name)
+			if _, err := i.EvalString(src); err != nil {
+				t.Error(err)
+			}
+		}(n)
+	}
+	wg.Wait()
+
+	result, err := i.EvalString(`(join spinner)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := fmt.Sprint(result), "20000"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
@@ -1,10 +1,10 @@
-package main
+package lisp
 
 import (
 	"fmt"
 	"math/big"
-	"strconv"
 	"strings"
+	"sync"
 )
 
 type ObjType uint8
@@ -14,12 +14,25 @@ const (
 	TypeSymbol = ObjType(iota)
 	TypePair
 	TypePrimitive
+	TypeBuiltin
 	TypeProcedure
 	TypeMacro
 	// parsing types
 	TypeCloseParen
+	// evaluator types
+	TypeTailCall
 	// data types
 	TypeNumber
+	TypeError
+	TypeString
+	TypeBoolean
+	TypeEmptyList
+	TypeVector
+	TypeHashTable
+	TypeChar
+	TypeThread
+	TypeChannel
+	TypeMutex
 )
 
 // All Lisp objects must satisfy this interface
@@ -28,41 +41,94 @@ type Obj interface {
 }
 
 var _ Obj = Primitive(nil)
+var _ Obj = (*Builtin)(nil)
 var _ Obj = &Procedure{}
 var _ Obj = &Macro{}
 var _ Obj = &Symbol{}
 var _ Obj = &CloseParen{}
 var _ Obj = &Pair{}
 var _ Obj = &Number{}
+var _ Obj = &TailCall{}
+var _ Obj = &Error{}
+var _ Obj = &String{}
+var _ Obj = &Boolean{}
+var _ Obj = &EmptyList{}
+var _ Obj = &Vector{}
+var _ Obj = &HashTable{}
+var _ Obj = &Char{}
+var _ Obj = &Thread{}
+var _ Obj = &Channel{}
+var _ Obj = &Mutex{}
+
+// Boolean is #t or #f, of which there's one each
+type Boolean struct {
+	value bool
+}
+
+func (*Boolean) Type() ObjType {
+	return TypeBoolean
+}
+
+// EmptyList is (), the end of every proper list, of which there's one
+type EmptyList struct{}
+
+func (*EmptyList) Type() ObjType {
+	return TypeEmptyList
+}
 
-// Symbol is an interned string (except with Gensym)
+// Equal returns whether o is the empty list
+func (n *EmptyList) Equal(o Obj) bool {
+	return o == Obj(n)
+}
+
+// Symbol is an interned string (except with Gensym). Symbols renamed by
+// syntax-rules share the string of the symbol they were renamed from, but
+// are different symbols, see syntaxrules.go.
 type Symbol struct {
-	s *string
+	s     *string
+	alias *alias // nil unless the symbol was renamed
 }
 
 func (s *Symbol) Type() ObjType {
 	return TypeSymbol
 }
 
-func Intern(s string) *Symbol {
-	interned, ok := symbols[s]
+// SymbolTable interns symbols. Each interpreter has its own table, which
+// starts out with the symbols the interpreter itself refers to.
+type SymbolTable struct {
+	mu sync.Mutex
+	// Interned symbols are NOT garbage collected
+	symbols map[string]*string
+}
+
+// symbols used by the interpreter, every SymbolTable starts with these
+var builtinSymbols = &SymbolTable{symbols: map[string]*string{}}
+
+func MakeSymbolTable() *SymbolTable {
+	builtinSymbols.mu.Lock()
+	defer builtinSymbols.mu.Unlock()
+	t := &SymbolTable{symbols: make(map[string]*string, len(builtinSymbols.symbols))}
+	for name, s := range builtinSymbols.symbols {
+		t.symbols[name] = s
+	}
+	return t
+}
+
+// Intern is safe to call from several goroutines at once
+func (t *SymbolTable) Intern(s string) *Symbol {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	interned, ok := t.symbols[s]
 	if !ok {
-		symbols[s] = &s
+		t.symbols[s] = &s
 		interned = &s
 	}
 	return &Symbol{s: interned}
 }
 
-// names can collide since it's not interned
-// the name is for debugging purposes only
-var gensymCounter uint64 = 0
-
-// generates an un-interned symbol
-// for use inside of macros only please and thank you
-func Gensym() *Symbol {
-	s := "__GEN-" + strconv.FormatUint(gensymCounter, 36)
-	gensymCounter++
-	return &Symbol{s: &s}
+// for package level symbols only, since builtinSymbols is shared
+func intern(s string) *Symbol {
+	return builtinSymbols.Intern(s)
 }
 
 func (s *Symbol) Equal(o Obj) bool {
@@ -100,17 +166,60 @@ func (CloseParen) Type() ObjType {
 	return TypeCloseParen
 }
 
+// TailCall is returned by primitives and procedures in place of a value when
+// the result is an expression left to evaluate, so Eval can loop on it instead
+// of recursing. It never escapes Eval.
+//
+// If Proc is set, the tail call is to apply Proc to the already evaluated
+// Args instead, and Expr is nil.
+type TailCall struct {
+	Expr Obj
+	Env  *Env
+	Proc Obj
+	Args []Obj
+}
+
+func (TailCall) Type() ObjType {
+	return TypeTailCall
+}
+
+func MakeTailCall(expr Obj, e *Env) *TailCall {
+	return &TailCall{Expr: expr, Env: e}
+}
+
+func MakeTailApply(proc Obj, args []Obj, e *Env) *TailCall {
+	return &TailCall{Env: e, Proc: proc, Args: args}
+}
+
+// Primitive is a special form implemented in Go. It gets its arguments
+// unevaluated, along with the environment it was called in.
 type Primitive func(Obj, *Env) Obj
 
 func (Primitive) Type() ObjType {
 	return TypePrimitive
 }
 
+// Builtin is a procedure implemented in Go. Its arguments are evaluated
+// before it's called, and the slice is only valid until it returns.
+// Builtins are objects as *Builtin, so that each has its own identity even
+// when they're closures made by the same code, like continuations.
+type Builtin func(args []Obj, e *Env) Obj
+
+func MakeBuiltin(fn Builtin) *Builtin {
+	return &fn
+}
+
+func (*Builtin) Type() ObjType {
+	return TypeBuiltin
+}
+
 type Procedure struct {
 	args     []Symbol
 	body     Obj
 	scope    *Env
 	variadic *Symbol // nil if not variadic
+	name     *Symbol // what it was first defined as, nil if anonymous
+	lambda   *lambda // compiled body for the VM, nil until it's needed
 }
 
 func (Procedure) Type() ObjType {
@@ -129,7 +238,10 @@ type Macro struct {
 	args     []Symbol
 	body     Obj
 	scope    *Env
-	variadic *Symbol // nil if not variadic
+	variadic *Symbol      // nil if not variadic
+	name     *Symbol      // what it was first defined as, nil if anonymous
+	lambda   *lambda      // compiled body for the VM, nil until it's needed
+	rules    *syntaxRules // set for macros made by syntax-rules, which have no body
 }
 
 func (Macro) Type() ObjType {
@@ -144,63 +256,231 @@ func MakeVariadicMacro(args []Symbol, variadic Symbol, body Obj, scope *Env) *Ma
 	return &Macro{args: args, body: body, scope: scope, variadic: &variadic}
 }
 
+// Number is an exact integer or rational, or an inexact real, see numbers.go
 type Number struct {
-	n *big.Int
+	kind  numKind
+	small int64
+	big   *big.Int // nil when the integer fits in small
+	rat   *big.Rat
+	float float64
 }
 
 func (Number) Type() ObjType {
 	return TypeNumber
 }
 
-func ParseNum(text []byte) *Number {
-	n := big.NewInt(0)
-	n.UnmarshalText(text)
-	return &Number{n: n}
+// String is an immutable string of unicode text
+type String struct {
+	s string
 }
 
-func MakeNum(n *big.Int) *Number {
-	if n == nil {
-		return &Number{n: big.NewInt(0)}
-	}
-	return &Number{n: n}
+func (String) Type() ObjType {
+	return TypeString
+}
+
+func MakeString(s string) *String {
+	return &String{s: s}
+}
+
+// Value returns the contents of the string
+func (s *String) Value() string {
+	return s.s
 }
 
+// Char is a unicode character
+type Char struct {
+	r rune
+}
+
+func (*Char) Type() ObjType {
+	return TypeChar
+}
+
+func MakeChar(r rune) *Char {
+	return &Char{r: r}
+}
+
+// Rune returns the character
+func (c *Char) Rune() rune {
+	return c.r
+}
+
+// Vector is a fixed length array of objects
+type Vector struct {
+	items []Obj
+}
+
+func (*Vector) Type() ObjType {
+	return TypeVector
+}
+
+// MakeVector makes a vector holding items, which it doesn't copy
+func MakeVector(items []Obj) *Vector {
+	return &Vector{items: items}
+}
+
+// Items returns the elements of the vector, which share its storage
+func (v *Vector) Items() []Obj {
+	return v.items
+}
+
+// HashTable maps keys to values, comparing keys with eq?, eqv? or equal?.
+// It remembers the order keys were added in, so that printing it and
+// listing its keys is repeatable.
+type HashTable struct {
+	test    *Symbol
+	buckets map[interface{}][]*hashEntry // keyed by hashKey
+	entries []*hashEntry                 // in the order they were added, including deleted ones
+	count   int                          // how many entries aren't deleted
+}
+
+func (*HashTable) Type() ObjType {
+	return TypeHashTable
+}
+
+// Thread is a procedure running on a goroutine of its own, started by spawn
+type Thread struct {
+	done   chan struct{} // closed when it's finished
+	result Obj
+	panic  interface{} // what it finished by panicking with, if it did
+}
+
+func (*Thread) Type() ObjType {
+	return TypeThread
+}
+
+// Channel passes objects between threads, like a Go channel
+type Channel struct {
+	ch chan Obj
+}
+
+func (*Channel) Type() ObjType {
+	return TypeChannel
+}
+
+// Mutex is a lock that one thread at a time can hold
+type Mutex struct {
+	ch chan struct{} // holds a value while it's locked
+}
+
+func (*Mutex) Type() ObjType {
+	return TypeMutex
+}
+
+// Env maps variables to values. The variables of compiled procedures are
+// kept in slots, which the VM gets at by position instead of by name, and
+// everything else, like globals and variables added by eval, in a map.
 type Env struct {
-	bindings map[Symbol]Obj
+	slots    []Obj          // nil until the variable is defined
+	names    []Symbol       // the variables in slots, shared by every call of a procedure
+	bindings map[Symbol]Obj // nil until something is bound that isn't in a slot
 	parent   *Env
+	interp   *Interpreter // inherited from the parent
 }
 
 func MakeEnv(parent *Env) *Env {
-	return &Env{
+	e := &Env{
 		bindings: map[Symbol]Obj{},
 		parent:   parent,
 	}
+	if parent != nil {
+		e.interp = parent.interp
+	}
+	return e
+}
+
+// makes an environment with slots for names, which slots must be as long as
+func makeSlotEnv(parent *Env, names []Symbol, slots []Obj) *Env {
+	return &Env{slots: slots, names: names, parent: parent, interp: parent.interp}
+}
+
+// the position of sym in e's slots, or -1. later names shadow earlier
+// ones, like in scope.lookup.
+func (e *Env) slot(sym *Symbol) int {
+	for n := len(e.names) - 1; n >= 0; n-- {
+		if e.names[n] == *sym {
+			return n
+		}
+	}
+	return -1
 }
 
 func (e *Env) Bind(sym *Symbol, o Obj) Obj {
+	if n := e.slot(sym); n >= 0 {
+		e.slots[n] = o
+		return o
+	}
+	if e.bindings == nil {
+		e.bindings = map[Symbol]Obj{}
+	}
+	e.changed(e.bindings[*sym], o)
 	e.bindings[*sym] = o
 	return o
 }
 
 func (e *Env) Set(sym *Symbol, o Obj) Obj {
-	if old, ok := e.bindings[*sym]; ok {
-		e.bindings[*sym] = o
-		return old
+	for ; e != nil; e = e.parent {
+		if n := e.slot(sym); n >= 0 && e.slots[n] != nil {
+			old := e.slots[n]
+			e.slots[n] = o
+			return old
+		}
+		if old, ok := e.bindings[*sym]; ok {
+			e.changed(old, o)
+			e.bindings[*sym] = o
+			return old
+		}
+	}
+	if sym.alias != nil {
+		return sym.alias.env.Set(sym.alias.sym, o)
+	}
+	err := MakeError(UnboundErrorSym, "tried to set unbound variable", sym)
+	err.Form = sym
+	panic(err)
+}
+
+// counts changes to bindings that are or were macros or special forms.
+// ones in slots don't matter, since the compiler never expands them, see
+// compiler.isLocal.
+func (e *Env) changed(old, o Obj) {
+	if e.interp != nil && (isSyntax(old) || isSyntax(o)) {
+		e.interp.syntaxChanges++
 	}
-	if e.parent != nil {
-		return e.parent.Set(sym, o)
+}
+
+func isSyntax(o Obj) bool {
+	switch o.(type) {
+	case *Macro, Primitive:
+		return true
 	}
-	panic(fmt.Sprintf("tried to set unbound variable %v", sym))
+	return false
+}
+
+// lookup is Resolve without the error
+func (e *Env) lookup(sym *Symbol) (Obj, bool) {
+	for ; e != nil; e = e.parent {
+		if n := e.slot(sym); n >= 0 && e.slots[n] != nil {
+			return e.slots[n], true
+		}
+		if o, ok := e.bindings[*sym]; ok {
+			return o, true
+		}
+	}
+	// a renamed symbol that nothing in the expansion bound refers to what
+	// it did where the macro was defined
+	if sym.alias != nil {
+		return sym.alias.env.lookup(sym.alias.sym)
+	}
+	return nil, false
 }
 
 func (e *Env) Resolve(sym *Symbol) Obj {
-	if o, ok := e.bindings[*sym]; ok {
+	if o, ok := e.lookup(sym); ok {
 		return o
 	}
-	if e.parent != nil {
-		return e.parent.Resolve(sym)
-	}
-	panic(fmt.Sprintf("tried to get unbound variable %v", sym))
+	err := MakeError(UnboundErrorSym, "tried to get unbound variable", sym)
+	err.Form = sym
+	panic(err)
 }
 
 func (e *Env) String() string {
@@ -208,7 +488,16 @@ func (e *Env) String() string {
 	s.WriteString("env ")
 	s.WriteString(fmt.Sprintf("%p", e))
 	s.WriteString(" {\n")
+	bindings := map[Symbol]Obj{}
+	for n, name := range e.names {
+		if e.slots[n] != nil {
+			bindings[name] = e.slots[n]
+		}
+	}
 	for k, v := range e.bindings {
+		bindings[k] = v
+	}
+	for k, v := range bindings {
 		s.WriteString(k.String())
 		s.WriteString(": ")
 		s.WriteString(v.(fmt.Stringer).String())
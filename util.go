@@ -1,12 +1,15 @@
-package main
-
-import "fmt"
+package lisp
 
 func boolToLisp(b bool) Obj {
 	if b {
 		return True
 	}
-	return Nil
+	return False
+}
+
+// List makes a proper list out of its arguments
+func List(objs ...Obj) Obj {
+	return sliceToList(objs)
 }
 
 func sliceToList(slice []Obj) Obj {
@@ -24,21 +27,19 @@ func sliceToList(slice []Obj) Obj {
 }
 
 func listToSlice(o Obj) []Obj {
-	slice := make([]Obj, 0)
-	for !Nil.Equal(o) {
-		pair, ok := o.(*Pair)
-		if !ok {
-			panic(fmt.Sprintf("expected list, got %v", o))
-		}
-		slice = append(slice, Car(pair))
-		o = Cdr(pair)
+	slice, tail := improperListToSlice(o)
+	if tail != nil {
+		panic(MakeError(TypeErrorSym, "expected list, got", tail))
 	}
 	return slice
 }
 
-// for a list potentially not ending in Nil, like in a variadic function
+// for a list potentially not ending in Nil, like in a variadic function.
+// lists that go round in a cycle raise an error rather than filling up
+// memory.
 func improperListToSlice(o Obj) ([]Obj, Obj) {
 	slice := make([]Obj, 0)
+	list, slow := o, o
 	for !Nil.Equal(o) {
 		pair, ok := o.(*Pair)
 		if !ok {
@@ -46,6 +47,13 @@ func improperListToSlice(o Obj) ([]Obj, Obj) {
 		}
 		slice = append(slice, Car(pair))
 		o = Cdr(pair)
+		// slow goes half as fast, so o catches up with it in a cycle
+		if len(slice)%2 == 0 {
+			slow = Cdr(slow.(*Pair))
+			if slow == o {
+				panic(MakeError(TypeErrorSym, "expected list, got circular list", list))
+			}
+		}
 	}
 	return slice, nil
 }
@@ -0,0 +1,169 @@
+package lisp
+
+import (
+	"fmt"
+)
+
+// checks that o is a vector, for primitives that only take vectors
+func vectorArg(name string, o Obj) *Vector {
+	v, ok := o.(*Vector)
+	if !ok {
+		panic(MakeError(TypeErrorSym, fmt.Sprintf("%v takes vector arguments", name), o))
+	}
+	return v
+}
+
+// checks that o is an index of v
+func vectorIndex(name string, v *Vector, o Obj) int {
+	k := intArg(name, o)
+	if k < 0 || k >= len(v.items) {
+		panic(MakeError(RangeErrorSym, fmt.Sprintf("%v index out of range", name), o))
+	}
+	return k
+}
+
+func IsVectorPrim(args []Obj, e *Env) Obj {
+	if len(args) != 1 {
+		panic(MakeError(ArityErrorSym, "vector? takes 1 argument"))
+	}
+	_, ok := args[0].(*Vector)
+	return boolToLisp(ok)
+}
+
+// (make-vector k [fill]), filled with #f if there's no fill
+func MakeVectorPrim(args []Obj, e *Env) Obj {
+	if len(args) != 1 && len(args) != 2 {
+		panic(MakeError(ArityErrorSym, "make-vector takes 1 or 2 arguments"))
+	}
+	k := intArg("make-vector", args[0])
+	if k < 0 {
+		panic(MakeError(RangeErrorSym, "make-vector length must not be negative", args[0]))
+	}
+	e.interp.allocate(k)
+	fill := Obj(False)
+	if len(args) == 2 {
+		fill = args[1]
+	}
+	items := make([]Obj, k)
+	for i := range items {
+		items[i] = fill
+	}
+	return MakeVector(items)
+}
+
+func VectorPrim(args []Obj, e *Env) Obj {
+	return MakeVector(append([]Obj{}, args...))
+}
+
+func VectorLengthPrim(args []Obj, e *Env) Obj {
+	if len(args) != 1 {
+		panic(MakeError(ArityErrorSym, "vector-length takes 1 argument"))
+	}
+	return MakeInt(int64(len(vectorArg("vector-length", args[0]).items)))
+}
+
+func VectorRefPrim(args []Obj, e *Env) Obj {
+	if len(args) != 2 {
+		panic(MakeError(ArityErrorSym, "vector-ref takes 2 arguments"))
+	}
+	v := vectorArg("vector-ref", args[0])
+	return v.items[vectorIndex("vector-ref", v, args[1])]
+}
+
+func VectorSetPrim(args []Obj, e *Env) Obj {
+	if len(args) != 3 {
+		panic(MakeError(ArityErrorSym, "vector-set! takes 3 arguments"))
+	}
+	v := vectorArg("vector-set!", args[0])
+	e.interp.changing(v)
+	v.items[vectorIndex("vector-set!", v, args[1])] = args[2]
+	return args[2]
+}
+
+// (vector-fill! v fill [start [end]])
+func VectorFillPrim(args []Obj, e *Env) Obj {
+	if len(args) < 2 || len(args) > 4 {
+		panic(MakeError(ArityErrorSym, "vector-fill! takes 2 to 4 arguments"))
+	}
+	v := vectorArg("vector-fill!", args[0])
+	start, end := vectorRange("vector-fill!", v, args[2:])
+	e.interp.changing(v)
+	for i := start; i < end; i++ {
+		v.items[i] = args[1]
+	}
+	return v
+}
+
+// the optional start and end of a part of v, which default to all of it
+func vectorRange(name string, v *Vector, args []Obj) (int, int) {
+	start, end := 0, len(v.items)
+	if len(args) > 0 {
+		start = intArg(name, args[0])
+	}
+	if len(args) > 1 {
+		end = intArg(name, args[1])
+	}
+	if start < 0 || end > len(v.items) || start > end {
+		panic(MakeError(RangeErrorSym, fmt.Sprintf("%v index out of range", name), args...))
+	}
+	return start, end
+}
+
+// (vector->list v [start [end]])
+func VectorToListPrim(args []Obj, e *Env) Obj {
+	if len(args) < 1 || len(args) > 3 {
+		panic(MakeError(ArityErrorSym, "vector->list takes 1 to 3 arguments"))
+	}
+	v := vectorArg("vector->list", args[0])
+	start, end := vectorRange("vector->list", v, args[1:])
+	e.interp.allocate(end - start)
+	return sliceToList(v.items[start:end])
+}
+
+func ListToVectorPrim(args []Obj, e *Env) Obj {
+	if len(args) != 1 {
+		panic(MakeError(ArityErrorSym, "list->vector takes 1 argument"))
+	}
+	return MakeVector(listToSlice(args[0]))
+}
+
+// the arguments to proc for each index, stopping at the shortest vector
+func vectorArgs(name string, args []Obj) [][]Obj {
+	if len(args) < 2 {
+		panic(MakeError(ArityErrorSym, fmt.Sprintf("%v takes at least 2 arguments", name)))
+	}
+	vs := make([]*Vector, len(args)-1)
+	n := -1
+	for i, arg := range args[1:] {
+		vs[i] = vectorArg(name, arg)
+		if n < 0 || len(vs[i].items) < n {
+			n = len(vs[i].items)
+		}
+	}
+	calls := make([][]Obj, n)
+	for k := range calls {
+		calls[k] = make([]Obj, len(vs))
+		for i, v := range vs {
+			calls[k][i] = v.items[k]
+		}
+	}
+	return calls
+}
+
+// (vector-map proc v ...)
+func VectorMapPrim(args []Obj, e *Env) Obj {
+	calls := vectorArgs("vector-map", args)
+	items := make([]Obj, len(calls))
+	for k, callArgs := range calls {
+		items[k] = Call(args[0], callArgs, e)
+	}
+	return MakeVector(items)
+}
+
+// (vector-for-each proc v ...)
+func VectorForEachPrim(args []Obj, e *Env) Obj {
+	for _, callArgs := range vectorArgs("vector-for-each", args) {
+		Call(args[0], callArgs, e)
+	}
+	return Nil
+}
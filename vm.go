@@ -0,0 +1,312 @@
+package lisp
+
+// vmFrame is a call in progress on the VM
+type vmFrame struct {
+	code  *code
+	pc    int
+	env   *Env
+	bp    int // where the frame's values start on the operand stack
+	depth int // where the frame's entry is on the interpreter's call stack
+}
+
+// vm runs compiled code. Calls between compiled procedures don't recurse
+// in Go, so only calls through Go, like from builtins, grow the Go stack.
+type vm struct {
+	interp *Interpreter
+	stack  []Obj
+	frames []vmFrame // the callers of frame, innermost last
+	frame  vmFrame
+}
+
+// run runs c in e and returns its value. depth is where the entry for c
+// on the interpreter's call stack is, which run pops. Code with no entry,
+// like top level forms, is run with depth len(i.stack), and its tail calls
+// push frames of their own, like a call to Eval would.
+func (i *Interpreter) run(c *code, e *Env, depth int) Obj {
+	vm := &vm{
+		interp: i,
+		stack:  make([]Obj, 0, 16),
+		frame:  vmFrame{code: c, env: e, depth: depth},
+	}
+	return vm.loop()
+}
+
+// vmApply is Call for the VM
+func (i *Interpreter) vmApply(proc Obj, args []Obj) Obj {
+	form := callForm(proc, args)
+	c := &code{
+		instrs: []instr{makeInstr(opTailCall, 0), makeInstr(opReturn, 0)},
+		calls:  []callSite{{form: form, nargs: len(args)}},
+	}
+	i.stack = append(i.stack, frame{form: form, proc: proc})
+	i.tick()
+	vm := &vm{
+		interp: i,
+		stack:  append([]Obj{proc}, args...),
+		frame:  vmFrame{code: c, env: i.global, depth: len(i.stack) - 1},
+	}
+	return vm.loop()
+}
+
+func (vm *vm) push(o Obj) {
+	vm.stack = append(vm.stack, o)
+}
+
+func (vm *vm) pop() Obj {
+	o := vm.stack[len(vm.stack)-1]
+	vm.stack = vm.stack[:len(vm.stack)-1]
+	return o
+}
+
+func (vm *vm) loop() Obj {
+	i := vm.interp
+	for {
+		fr := &vm.frame
+		in := fr.code.instrs[fr.pc]
+		fr.pc++
+		switch in.op() {
+		case opConst:
+			vm.push(fr.code.consts[in.arg()])
+		case opLoad:
+			vm.push(fr.env.Resolve(fr.code.consts[in.arg()].(*Symbol)))
+		case opLoadLocal:
+			vm.push(fr.env.loadLocal(&fr.code.locals[in.arg()]))
+		case opHead:
+			vm.head(&fr.code.calls[in.arg()])
+		case opFrame, opTailFrame:
+			site := &fr.code.calls[in.arg()]
+			proc := vm.stack[len(vm.stack)-1]
+			if site.syntax != nil {
+				proc = site.syntax
+			}
+			if in.op() == opTailFrame {
+				i.pushFrame(fr.depth, site.form, proc)
+			} else {
+				i.stack = append(i.stack, frame{form: site.form, proc: proc})
+			}
+			if site.syntax == nil {
+				i.tick()
+			}
+		case opSet:
+			sym := fr.code.consts[in.arg()].(*Symbol)
+			vm.push(fr.env.Set(sym, vm.pop()))
+		case opSetLocal:
+			vm.push(fr.env.setLocal(&fr.code.locals[in.arg()], vm.pop()))
+		case opDefine:
+			sym := fr.code.consts[in.arg()].(*Symbol)
+			fr.env.Bind(sym, nameProc(vm.stack[len(vm.stack)-1], sym))
+		case opDefineLocal:
+			l := &fr.code.locals[in.arg()]
+			fr.env.slots[l.index] = nameProc(vm.stack[len(vm.stack)-1], l.sym)
+		case opPop:
+			vm.pop()
+		case opDup:
+			vm.push(vm.stack[len(vm.stack)-1])
+		case opJump:
+			fr.pc = in.arg()
+		case opJumpIfFalse:
+			if i.isFalse(vm.pop()) {
+				fr.pc = in.arg()
+			}
+		case opLambda:
+			l := fr.code.lambdas[in.arg()]
+			vm.push(&Procedure{args: l.args, body: l.body, scope: fr.env, variadic: l.variadic, lambda: l})
+		case opCall, opTailCall:
+			site := &fr.code.calls[in.arg()]
+			sp := len(vm.stack) - site.nargs - 1
+			vm.call(vm.stack[sp], vm.stack[sp+1:], sp, in.op() == opTailCall)
+		case opReturn:
+			result := vm.pop()
+			i.stack = i.stack[:fr.depth]
+			if len(vm.frames) == 0 {
+				return result
+			}
+			vm.stack = vm.stack[:fr.bp]
+			vm.frame = vm.frames[len(vm.frames)-1]
+			vm.frames = vm.frames[:len(vm.frames)-1]
+			vm.push(result)
+		case opEval:
+			vm.push(Eval(fr.code.consts[in.arg()], fr.env))
+		case opGuard:
+			vm.push(i.runGuard(fr.code.guards[in.arg()], fr.env))
+		case opRaise:
+			raiseCompiled(fr.code.consts[in.arg()])
+		}
+	}
+}
+
+// pushes the procedure at the head of a call. If it's a macro or special
+// form, which it wasn't when the call was compiled, Eval does the whole
+// call instead.
+func (vm *vm) head(site *callSite) {
+	fr := &vm.frame
+	head := fr.env.Resolve(site.form.(*Pair).Car.(*Symbol))
+	switch head.(type) {
+	case *Macro, Primitive:
+		vm.push(Eval(site.form, fr.env))
+		fr.pc = site.end
+	default:
+		vm.push(head)
+	}
+}
+
+// calls proc with args, which are on the stack above sp along with proc.
+// The call's frame was pushed by opFrame, or by opTailFrame if tail is set,
+// in which case the call replaces the current one.
+func (vm *vm) call(proc Obj, args []Obj, sp int, tail bool) {
+	i := vm.interp
+	// where the frames the call makes start, like base in Eval
+	entry := vm.frame.depth
+	if !tail {
+		entry = len(i.stack) - 1
+	}
+	for {
+		switch p := proc.(type) {
+		case *Procedure:
+			// args stay put on the stack until they're bound, since nothing
+			// is pushed in between
+			vm.enter(sp, entry, tail)
+			vm.frame.code = i.procCode(p)
+			vm.frame.env = bindSlots("procedure", p.lambda, args, p.scope)
+			return
+		case *Builtin:
+			result := (*p)(args, vm.frame.env)
+			vm.stack = vm.stack[:sp]
+			tc, ok := result.(*TailCall)
+			if !ok {
+				vm.done(result, entry, tail)
+				return
+			}
+			if tc.Proc == nil {
+				c := i.compile(tc.Expr, tc.Env, true)
+				vm.enter(sp, entry, tail)
+				vm.frame.code, vm.frame.env = c, tc.Env
+				return
+			}
+			proc, args = tc.Proc, tc.Args
+			i.pushFrame(entry, callForm(proc, args), proc)
+			i.tick()
+		default:
+			result := i.applyOther(proc, args, vm.frame.env)
+			vm.stack = vm.stack[:sp]
+			vm.done(result, entry, tail)
+			return
+		}
+	}
+}
+
+// pushes the result of a call that didn't need a frame on the VM, popping
+// the frames it made unless it's a tail call, whose frames are popped when
+// the current one returns
+func (vm *vm) done(result Obj, entry int, tail bool) {
+	if !tail {
+		vm.interp.stack = vm.interp.stack[:entry]
+	}
+	vm.push(result)
+}
+
+// sets up a frame for a call, leaving the code and environment to the
+// caller. entry is where the call's frames start on the interpreter's call
+// stack.
+func (vm *vm) enter(sp, entry int, tail bool) {
+	if tail {
+		vm.stack = vm.stack[:vm.frame.bp]
+		vm.frame.pc = 0
+		return
+	}
+	vm.stack = vm.stack[:sp]
+	vm.frames = append(vm.frames, vm.frame)
+	vm.frame = vmFrame{bp: sp, depth: entry}
+}
+
+// applies special forms and macros to already evaluated arguments like
+// Apply does, and raises for things that can't be applied
+func (i *Interpreter) applyOther(proc Obj, args []Obj, e *Env) Obj {
+	switch proc.(type) {
+	case Primitive, *Macro:
+		return Eval(callForm(proc, args), e)
+	}
+	panic(MakeError(TypeErrorSym, "tried to apply a non-procedure", proc))
+}
+
+// runs a compiled guard form, see GuardPrim
+func (i *Interpreter) runGuard(g *guard, e *Env) (result Obj) {
+	outer := i.handlers
+	depth := len(i.stack)
+	i.handlers = append(i.handlers[:len(i.handlers):len(i.handlers)], nil)
+	defer func() {
+		i.handlers = outer
+		r := recover()
+		if r == nil {
+			return
+		}
+		condition, ok := i.conditionOf(r)
+		if !ok {
+			panic(r)
+		}
+		i.stack = i.stack[:depth]
+
+		scope := makeSlotEnv(e, g.names, []Obj{condition})
+		result = i.run(g.handler, scope, len(i.stack))
+		if result == Obj(noClauseMatched) {
+			panic(i.deliver(r, e))
+		}
+	}()
+
+	return i.run(g.body, e, len(i.stack))
+}
+
+// names anonymous procedures for backtraces, like DefinePrim
+func nameProc(value Obj, sym *Symbol) Obj {
+	if proc, ok := value.(*Procedure); ok && proc.name == nil {
+		proc.name = sym
+	}
+	return value
+}
+
+// the value of a local variable, from its slot. It's looked up by name
+// instead if it hasn't been defined yet, or if eval or a define the
+// compiler didn't see might have bound something in between that shadows
+// it, since those are the only things that put bindings in these
+// environments.
+func (e *Env) loadLocal(l *local) Obj {
+	scope := e
+	for depth := l.depth; depth > 0; depth-- {
+		if scope.bindings != nil {
+			return e.Resolve(l.sym)
+		}
+		scope = scope.parent
+	}
+	if o := scope.slots[l.index]; o != nil {
+		return o
+	}
+	return e.Resolve(l.sym)
+}
+
+// like loadLocal, for set!
+func (e *Env) setLocal(l *local, o Obj) Obj {
+	scope := e
+	for depth := l.depth; depth > 0; depth-- {
+		if scope.bindings != nil {
+			return e.Set(l.sym, o)
+		}
+		scope = scope.parent
+	}
+	old := scope.slots[l.index]
+	if old == nil {
+		return e.Set(l.sym, o)
+	}
+	scope.slots[l.index] = o
+	return old
+}
+
+// raises a condition saved by the compiler. errors are copied so that
+// each raise gets its own backtrace.
+func raiseCompiled(condition Obj) {
+	if err, ok := condition.(*Error); ok {
+		copied := *err
+		copied.Backtrace = nil
+		panic(&copied)
+	}
+	raise(condition)
+}